@@ -24,14 +24,79 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// AgentMode selects whether the agents change the host.
+// +kubebuilder:validation:Enum=enforce;audit
+type AgentMode string
+
+const (
+	// AgentModeEnforce applies rules and routes to the nodes.
+	AgentModeEnforce AgentMode = "enforce"
+	// AgentModeAudit only reports the changes the agents would make, as WouldAdd/WouldRemove node
+	// states and metrics, and never touches the kernel or acknowledges absent IPRuleConfigs.
+	AgentModeAudit AgentMode = "audit"
+)
+
+// DefaultAgentPool is the pool of IPRules that do not name one: the Agent named "agent", the only
+// Agent before pools were introduced. Every Agent is a pool of its own, named after the Agent.
+const DefaultAgentPool = "agent"
+
 // AgentStatus defines the observed state of Agent.
 type AgentSpec struct {
 	// Image optional override for the agent container image.
 	Image string `json:"image,omitempty"`
 	// NodeSelector restricts the target nodes on which the agent pods will be scheduled.
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// NodeLabelSelector restricts the target nodes with label selector expressions (In, NotIn,
+	// Exists, DoesNotExist). It is ANDed with NodeSelector and Affinity and applied to the agent
+	// pods as required node affinity.
+	// +optional
+	NodeLabelSelector *metav1.LabelSelector `json:"nodeLabelSelector,omitempty"`
 	// Tolerations applied to the agent pods.
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// PodMonitor creates a monitoring.coreos.com/v1 PodMonitor scraping the agent metrics
+	// (port 9641). Requires the Prometheus Operator CRDs.
+	// +optional
+	PodMonitor bool `json:"podMonitor,omitempty"`
+	// Resources of the agent container. Defaults to requests of 10m CPU / 16Mi memory and limits
+	// of 100m CPU / 64Mi memory.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// PriorityClassName of the agent pods, e.g. system-node-critical.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// ImagePullPolicy of the agent container. Defaults to IfNotPresent.
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// ImagePullSecrets for pulling the agent image from a private registry. The secrets must
+	// exist in the namespace of the Agent.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Affinity of the agent pods.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// PodAnnotations are added to the agent pods.
+	// +optional
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// Env holds additional environment variables of the agent container. They are appended to
+	// the ones set by the operator.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// LogLevel of the agent.
+	// +kubebuilder:validation:Enum=debug;info;error
+	// +optional
+	LogLevel string `json:"logLevel,omitempty"`
+	// ReconcilePeriod is the interval of the agent's full resync that corrects drift on the
+	// host. Defaults to 5m.
+	// +kubebuilder:validation:XValidation:rule="duration(self) > duration('0s')",message="reconcilePeriod must be positive"
+	// +optional
+	ReconcilePeriod *metav1.Duration `json:"reconcilePeriod,omitempty"`
+	// Mode is enforce (default) to apply rules and routes, or audit to only report the changes the
+	// agents would make (WouldAdd/WouldRemove node states and the iprule_agent_audit_planned_changes
+	// metric) without touching the nodes.
+	// +kubebuilder:default=enforce
+	// +optional
+	Mode AgentMode `json:"mode,omitempty"`
 }
 
 type AgentConditionType string
@@ -51,7 +116,7 @@ type AgentStatus struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
-// +kubebuilder:validation:XValidation:rule="self.metadata.name == 'agent'",message="Agent resource name must be 'agent'"
+// +kubebuilder:validation:XValidation:rule="size(self.metadata.name) <= 63",message="Agent name must be at most 63 characters, it names the agent pool in pod labels"
 // +kubebuilder:resource:scope=Namespaced
 
 // Agent is the Schema for the agents API.
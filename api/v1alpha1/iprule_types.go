@@ -23,26 +23,222 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// AddressSource selects which addresses of a Service are matched against IPRuleSpec.Cidr.
+// +kubebuilder:validation:Enum=IngressIP;IngressHostname;LoadBalancerIP;ExternalIP;ClusterIP
+type AddressSource string
+
+const (
+	// AddressSourceIngressIP matches status.loadBalancer.ingress[].ip (the default).
+	AddressSourceIngressIP AddressSource = "IngressIP"
+	// AddressSourceIngressHostname matches the addresses status.loadBalancer.ingress[].hostname resolves to.
+	AddressSourceIngressHostname AddressSource = "IngressHostname"
+	// AddressSourceLoadBalancerIP matches spec.loadBalancerIP, also while the request is still pending.
+	AddressSourceLoadBalancerIP AddressSource = "LoadBalancerIP"
+	// AddressSourceExternalIP matches spec.externalIPs.
+	AddressSourceExternalIP AddressSource = "ExternalIP"
+	// AddressSourceClusterIP matches the service's ClusterIPs themselves.
+	AddressSourceClusterIP AddressSource = "ClusterIP"
+)
+
+// IPProtocol is an IP protocol an ip rule can match on ("ipproto").
+// +kubebuilder:validation:Enum=tcp;udp;sctp;icmp;ipv6-icmp
+type IPProtocol string
+
+const (
+	IPProtocolTCP    IPProtocol = "tcp"
+	IPProtocolUDP    IPProtocol = "udp"
+	IPProtocolSCTP   IPProtocol = "sctp"
+	IPProtocolICMP   IPProtocol = "icmp"
+	IPProtocolICMPv6 IPProtocol = "ipv6-icmp"
+)
+
+// PortRange is an inclusive range of layer 4 ports.
+type PortRange struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Start int32 `json:"start"`
+	// End of the range. If unset, only Start is matched.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	End int32 `json:"end,omitempty"`
+}
+
+// UIDRange is an inclusive range of user ids of the sending socket.
+type UIDRange struct {
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4294967295
+	Start int64 `json:"start"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4294967295
+	End int64 `json:"end"`
+}
+
+// RuleSelector holds the optional ip rule selectors applied on top of the service IP ("from").
+// Every field that is set narrows the rule; an empty selector matches all traffic from the IP.
+type RuleSelector struct {
+	// FwMark matches the packet firewall mark ("fwmark").
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4294967295
+	// +optional
+	FwMark *int64 `json:"fwMark,omitempty"`
+	// FwMask is applied to the firewall mark before comparing. Requires FwMark; defaults to 0xffffffff.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4294967295
+	// +optional
+	FwMask *int64 `json:"fwMask,omitempty"`
+	// IIF matches the incoming interface ("iif"); "lo" matches locally generated traffic.
+	// +kubebuilder:validation:MaxLength=15
+	// +optional
+	IIF string `json:"iif,omitempty"`
+	// OIF matches the outgoing interface of sockets bound to a device ("oif").
+	// +kubebuilder:validation:MaxLength=15
+	// +optional
+	OIF string `json:"oif,omitempty"`
+	// Dst matches the destination prefix ("to"). It must be of the same IP family as the service IP.
+	// +optional
+	Dst string `json:"dst,omitempty"`
+	// TOS matches the type of service byte ("tos").
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=255
+	// +optional
+	TOS int32 `json:"tos,omitempty"`
+	// IPProto matches the IP protocol ("ipproto").
+	// +optional
+	IPProto IPProtocol `json:"ipProto,omitempty"`
+	// SrcPort matches the source port range ("sport"). Requires IPProto tcp, udp or sctp.
+	// +optional
+	SrcPort *PortRange `json:"srcPort,omitempty"`
+	// DstPort matches the destination port range ("dport"). Requires IPProto tcp, udp or sctp.
+	// +optional
+	DstPort *PortRange `json:"dstPort,omitempty"`
+	// UIDRange matches the user id of the sending socket ("uidrange").
+	// +optional
+	UIDRange *UIDRange `json:"uidRange,omitempty"`
+	// Invert negates the whole selector ("not").
+	// +optional
+	Invert bool `json:"invert,omitempty"`
+}
+
+// RuleActionType is what the kernel does with packets matching an ip rule.
+// +kubebuilder:validation:Enum=Lookup;Goto;Blackhole;Unreachable;Prohibit
+type RuleActionType string
+
+const (
+	// RuleActionLookup resolves the route in the rule's table ("lookup <table>", the default).
+	RuleActionLookup RuleActionType = "Lookup"
+	// RuleActionGoto continues the rule evaluation at GotoPriority ("goto <priority>").
+	RuleActionGoto RuleActionType = "Goto"
+	// RuleActionBlackhole silently drops the packets ("blackhole").
+	RuleActionBlackhole RuleActionType = "Blackhole"
+	// RuleActionUnreachable drops the packets with "network unreachable" ("unreachable").
+	RuleActionUnreachable RuleActionType = "Unreachable"
+	// RuleActionProhibit drops the packets with "administratively prohibited" ("prohibit").
+	RuleActionProhibit RuleActionType = "Prohibit"
+)
+
+// RuleAction is the action of an ip rule.
+type RuleAction struct {
+	// Action defaults to Lookup. The table is only used by Lookup.
+	// +optional
+	Action RuleActionType `json:"action,omitempty"`
+	// GotoPriority is the rule priority evaluation continues at for the Goto action. It must be
+	// higher than the rule's own priority.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4294967295
+	// +optional
+	GotoPriority int64 `json:"gotoPriority,omitempty"`
+	// SuppressPrefixLength ignores lookup results with a prefix length of this value or less
+	// ("suppress_prefixlength"), e.g. 0 lets everything but the default route of the table win.
+	// Only valid for Lookup with a table below 256.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	// +optional
+	SuppressPrefixLength *int32 `json:"suppressPrefixLength,omitempty"`
+}
+
 // IpRuleSpec defines the desired state of IpRule.
 type IPRuleSpec struct {
-	// Table is the routing table number to use for created rules. If 0, a default will be used by the agent
-	Table int `json:"table"`
-	// Priority is the rule priority used. If 0, a default will be used by the agent
+	// Table is the routing table number to use for created rules. If unset, the operator default
+	// (--default-table) is written into the object on admission.
+	// +optional
+	Table int `json:"table,omitempty"`
+	// TableName refers to a table by the name of its RoutingTable instead of by number. The
+	// operator resolves it to the RoutingTable's table. Mutually exclusive with Table.
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	TableName string `json:"tableName,omitempty"`
+	// VRF routes into the table of the VRF device of that name. Each agent resolves the table on
+	// its own node, so the VRF may use a different table id per node. Mutually exclusive with Table
+	// and TableName, and only valid for the Lookup action.
+	// +kubebuilder:validation:MaxLength=15
+	// +optional
+	VRF string `json:"vrf,omitempty"`
+	// Priority is the rule priority used. If unset, the operator default (--default-priority) is
+	// written into the object on admission.
+	// +optional
 	Priority int `json:"priority,omitempty"`
 	// SubnetTableMappings defines which routing table/priority to use for any LB IP within the given CIDR subnets
 	Cidr string `json:"cidr"`
+	// NamespaceSelector restricts the rule to services in namespaces matching the selector.
+	// If unset, services in all namespaces are considered.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ServiceSelector restricts the rule to services whose labels match the selector.
+	// If unset, all services are considered.
+	// +optional
+	ServiceSelector *metav1.LabelSelector `json:"serviceSelector,omitempty"`
+	// AddressSources lists the service addresses matched against Cidr. A service matches if any
+	// address of any listed source lies within Cidr. Defaults to [IngressIP].
+	// +listType=set
+	// +optional
+	AddressSources []AddressSource `json:"addressSources,omitempty"`
+	// AgentPool is the name of the Agent whose nodes apply the rules. Each Agent runs its own
+	// DaemonSet, so IPRules can be targeted at node pools with different uplinks. Defaults to the
+	// Agent named "agent".
+	// +kubebuilder:validation:MaxLength=63
+	// +optional
+	AgentPool string `json:"agentPool,omitempty"`
+	// NodeSelector restricts the rules to the nodes of the agent pool whose labels match, e.g. the
+	// egress gateways. If unset, the rules apply on every node of the pool.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// RuleSelector narrows the generated ip rules further; it is copied into every IPRuleConfig.
+	RuleSelector `json:",inline"`
+	// RuleAction is what the generated ip rules do with matching packets; it is copied into every
+	// IPRuleConfig.
+	RuleAction `json:",inline"`
 }
 
+// AnnotationAllowReservedTable opts an IPRule (and the IPRuleConfigs generated from it) into using
+// one of the kernel's reserved routing tables (253 default, 254 main, 255 local).
+const AnnotationAllowReservedTable = "iprule.operator.brtrm.dev/allow-reserved-table"
+
 // State constants for IPRuleConfig.Spec.State
 const (
 	StatePresent = "present"
 	StateAbsent  = "absent"
 )
 
+type IPRuleConditionType string
+
+const (
+	// IPRuleConditionReady is True once the IPRule was evaluated and its IPRuleConfigs are in sync.
+	IPRuleConditionReady IPRuleConditionType = "Ready"
+	// IPRuleConditionInvalidCIDR is True when spec.cidr cannot be parsed; the rule matches nothing then.
+	IPRuleConditionInvalidCIDR IPRuleConditionType = "InvalidCIDR"
+)
+
 // IPRuleStatus defines the observed state of IPRule.
 type IPRuleStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation last evaluated by the controller.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// MatchedServices is the number of services with an address from spec.addressSources inside spec.cidr.
+	// +optional
+	MatchedServices int32 `json:"matchedServices"`
+	// ConfigCount is the number of IPRuleConfigs generated for this IPRule.
+	// +optional
+	ConfigCount int32 `json:"configCount"`
 	// Conditions represent the latest available observations of an object's state
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -50,6 +246,15 @@ type IPRuleStatus struct {
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="CIDR",type=string,JSONPath=`.spec.cidr`
+// +kubebuilder:printcolumn:name="Table",type=integer,JSONPath=`.spec.table`
+// +kubebuilder:printcolumn:name="Table Name",type=string,JSONPath=`.spec.tableName`
+// +kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+// +kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.agentPool`
+// +kubebuilder:printcolumn:name="Services",type=integer,JSONPath=`.status.matchedServices`
+// +kubebuilder:printcolumn:name="Configs",type=integer,JSONPath=`.status.configCount`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // IPRule is the Schema for the iprules API.
 type IPRule struct {
@@ -69,19 +274,98 @@ type IPRuleList struct {
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Service IP",type=string,JSONPath=`.spec.serviceIP`
+// +kubebuilder:printcolumn:name="Table",type=integer,JSONPath=`.spec.table`
+// +kubebuilder:printcolumn:name="VRF",type=string,JSONPath=`.spec.vrf`
+// +kubebuilder:printcolumn:name="Pool",type=string,JSONPath=`.spec.agentPool`
+// +kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.spec.state`
+// +kubebuilder:printcolumn:name="Applied",type=integer,JSONPath=`.status.appliedNodes`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failedNodes`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // IPRuleConfig is a generated configuration per Service ClusterIP
 type IPRuleConfig struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
-	Spec              IPRuleConfigSpec `json:"spec,omitempty"`
+	Spec              IPRuleConfigSpec   `json:"spec,omitempty"`
+	Status            IPRuleConfigStatus `json:"status,omitempty"`
 }
 
 type IPRuleConfigSpec struct {
+	// Table is 0 for rules routing into a VRF.
 	Table     int    `json:"table"`
 	Priority  int    `json:"priority,omitempty"`
 	ServiceIP string `json:"serviceIP"`
 	State     string `json:"state"`
+	// VRF is the VRF device whose table the agent resolves on its node, instead of Table.
+	// +optional
+	VRF string `json:"vrf,omitempty"`
+	// AgentPool is the Agent whose agents apply the rule. Empty selects the default pool.
+	// +optional
+	AgentPool string `json:"agentPool,omitempty"`
+	// NodeSelector limits the nodes applying the rule. Unset selects every node of the pool.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// RuleSelector holds the selectors of the owning IPRule.
+	RuleSelector `json:",inline"`
+	// RuleAction holds the action of the owning IPRule.
+	RuleAction `json:",inline"`
+}
+
+// Node states reported by the agents in IPRuleConfigStatus.Nodes
+const (
+	NodeStateApplied = "Applied"
+	NodeStateFailed  = "Failed"
+	// NodeStateRemoved acknowledges that the rule of an absent IPRuleConfig is gone from the node.
+	// The controller deletes the IPRuleConfig once every agent node reported it.
+	NodeStateRemoved = "Removed"
+	// NodeStateWouldAdd and NodeStateWouldRemove are reported by agents in audit mode, which
+	// never change the host: the rule (or a route of a RoutingTable) is missing on the node,
+	// respectively the rule of an absent IPRuleConfig is still in place.
+	NodeStateWouldAdd    = "WouldAdd"
+	NodeStateWouldRemove = "WouldRemove"
+)
+
+// NodeRuleStatus is the state of a rule (or the routes of a RoutingTable) on a single node, as
+// reported by the agent running there.
+type NodeRuleStatus struct {
+	// NodeName is the node the entry belongs to.
+	NodeName string `json:"nodeName"`
+	// State is Applied when the rule is in place on the node, Removed once the rule of an absent
+	// IPRuleConfig was deleted from the node, Failed otherwise. Agents in audit mode report
+	// WouldAdd and WouldRemove for the changes they would make.
+	State string `json:"state"`
+	// LastError holds the last error returned while applying the rule.
+	LastError string `json:"lastError,omitempty"`
+	// ObservedGeneration is the IPRuleConfig generation the agent acted on.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastUpdateTime is the time the agent last changed this entry.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// IPRuleConfigStatus defines the observed state of IPRuleConfig.
+type IPRuleConfigStatus struct {
+	// AppliedNodes is the number of nodes reporting the rule in place.
+	// +optional
+	AppliedNodes int32 `json:"appliedNodes"`
+	// FailedNodes is the number of nodes that failed to apply the rule.
+	// +optional
+	FailedNodes int32 `json:"failedNodes"`
+	// Nodes holds one entry per node. Each agent owns its own entry (server-side apply).
+	// +listType=map
+	// +listMapKey=nodeName
+	// +optional
+	Nodes []NodeRuleStatus `json:"nodes,omitempty"`
+	// AbsentSince is when the controller first saw the IPRuleConfig absent. The cleanup timeout
+	// (--absent-cleanup-timeout) counts from here.
+	// +optional
+	AbsentSince *metav1.Time `json:"absentSince,omitempty"`
+	// PendingNodes lists the nodes with a ready agent that have not yet reported the rule of the
+	// absent IPRuleConfig as Removed.
+	// +optional
+	PendingNodes []string `json:"pendingNodes,omitempty"`
 }
 
 // +kubebuilder:object:root=true
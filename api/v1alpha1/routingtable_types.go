@@ -0,0 +1,110 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Route is a single route of a routing table.
+type Route struct {
+	// Destination is the destination prefix, e.g. 0.0.0.0/0 for the default route.
+	Destination string `json:"destination"`
+	// Gateway is the next hop. If unset, Destination is on-link and Device is required.
+	// +optional
+	Gateway string `json:"gateway,omitempty"`
+	// Device is the output interface. It must exist on the node.
+	// +kubebuilder:validation:MaxLength=15
+	// +optional
+	Device string `json:"device,omitempty"`
+	// Metric is the route priority; lower wins.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Metric int32 `json:"metric,omitempty"`
+	// OnLink treats Gateway as directly reachable on Device even if no prefix covers it ("onlink").
+	// +optional
+	OnLink bool `json:"onLink,omitempty"`
+}
+
+// RouteOverride replaces routes on the nodes matching NodeSelector.
+type RouteOverride struct {
+	// NodeSelector selects the nodes the override applies to.
+	NodeSelector metav1.LabelSelector `json:"nodeSelector"`
+	// Routes replace the routes with the same destination; routes to new destinations are added.
+	Routes []Route `json:"routes"`
+}
+
+// RoutingTableSpec defines the desired state of RoutingTable.
+type RoutingTableSpec struct {
+	// Table is the routing table id the routes are installed into.
+	Table int `json:"table"`
+	// Routes are installed on every node the agent runs on.
+	// +optional
+	Routes []Route `json:"routes,omitempty"`
+	// NodeOverrides adjust Routes per node. Overrides are applied in order, so the last matching
+	// override wins for a destination.
+	// +optional
+	NodeOverrides []RouteOverride `json:"nodeOverrides,omitempty"`
+}
+
+// RoutingTableStatus defines the observed state of RoutingTable.
+type RoutingTableStatus struct {
+	// AppliedNodes is the number of nodes reporting all routes in place.
+	// +optional
+	AppliedNodes int32 `json:"appliedNodes"`
+	// FailedNodes is the number of nodes that failed to apply at least one route.
+	// +optional
+	FailedNodes int32 `json:"failedNodes"`
+	// Nodes holds one entry per node. Each agent owns its own entry (server-side apply).
+	// +listType=map
+	// +listMapKey=nodeName
+	// +optional
+	Nodes []NodeRuleStatus `json:"nodes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Table",type=integer,JSONPath=`.spec.table`
+// +kubebuilder:printcolumn:name="Applied",type=integer,JSONPath=`.status.appliedNodes`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failedNodes`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// RoutingTable declares the routes of a routing table. The agents install them on their node,
+// so the tables referenced by IPRules no longer have to be configured by hand. The object name
+// doubles as the table name: IPRules can refer to it by spec.tableName, and the agents register
+// it in the node's rt_tables so `ip rule` and `ip route` show it.
+type RoutingTable struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RoutingTableSpec   `json:"spec,omitempty"`
+	Status RoutingTableStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RoutingTableList contains a list of RoutingTable.
+type RoutingTableList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RoutingTable `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RoutingTable{}, &RoutingTableList{})
+}
@@ -21,8 +21,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -95,13 +95,52 @@ func (in *AgentSpec) DeepCopyInto(out *AgentSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.NodeLabelSelector != nil {
+		in, out := &in.NodeLabelSelector, &out.NodeLabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Tolerations != nil {
 		in, out := &in.Tolerations, &out.Tolerations
-		*out = make([]v1.Toleration, len(*in))
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(corev1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]corev1.EnvVar, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ReconcilePeriod != nil {
+		in, out := &in.ReconcilePeriod, &out.ReconcilePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentSpec.
@@ -119,7 +158,7 @@ func (in *AgentStatus) DeepCopyInto(out *AgentStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]metav1.Condition, len(*in))
+		*out = make([]v1.Condition, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -141,7 +180,7 @@ func (in *IPRule) DeepCopyInto(out *IPRule) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -168,7 +207,8 @@ func (in *IPRuleConfig) DeepCopyInto(out *IPRuleConfig) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRuleConfig.
@@ -224,6 +264,13 @@ func (in *IPRuleConfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPRuleConfigSpec) DeepCopyInto(out *IPRuleConfigSpec) {
 	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	in.RuleSelector.DeepCopyInto(&out.RuleSelector)
+	in.RuleAction.DeepCopyInto(&out.RuleAction)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRuleConfigSpec.
@@ -236,6 +283,37 @@ func (in *IPRuleConfigSpec) DeepCopy() *IPRuleConfigSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPRuleConfigStatus) DeepCopyInto(out *IPRuleConfigStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeRuleStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AbsentSince != nil {
+		in, out := &in.AbsentSince, &out.AbsentSince
+		*out = (*in).DeepCopy()
+	}
+	if in.PendingNodes != nil {
+		in, out := &in.PendingNodes, &out.PendingNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRuleConfigStatus.
+func (in *IPRuleConfigStatus) DeepCopy() *IPRuleConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IPRuleConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPRuleList) DeepCopyInto(out *IPRuleList) {
 	*out = *in
@@ -271,6 +349,28 @@ func (in *IPRuleList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPRuleSpec) DeepCopyInto(out *IPRuleSpec) {
 	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServiceSelector != nil {
+		in, out := &in.ServiceSelector, &out.ServiceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AddressSources != nil {
+		in, out := &in.AddressSources, &out.AddressSources
+		*out = make([]AddressSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	in.RuleSelector.DeepCopyInto(&out.RuleSelector)
+	in.RuleAction.DeepCopyInto(&out.RuleAction)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPRuleSpec.
@@ -288,7 +388,7 @@ func (in *IPRuleStatus) DeepCopyInto(out *IPRuleStatus) {
 	*out = *in
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
-		*out = make([]metav1.Condition, len(*in))
+		*out = make([]v1.Condition, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -304,3 +404,253 @@ func (in *IPRuleStatus) DeepCopy() *IPRuleStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeRuleStatus) DeepCopyInto(out *NodeRuleStatus) {
+	*out = *in
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeRuleStatus.
+func (in *NodeRuleStatus) DeepCopy() *NodeRuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeRuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortRange) DeepCopyInto(out *PortRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortRange.
+func (in *PortRange) DeepCopy() *PortRange {
+	if in == nil {
+		return nil
+	}
+	out := new(PortRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Route) DeepCopyInto(out *Route) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Route.
+func (in *Route) DeepCopy() *Route {
+	if in == nil {
+		return nil
+	}
+	out := new(Route)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouteOverride) DeepCopyInto(out *RouteOverride) {
+	*out = *in
+	in.NodeSelector.DeepCopyInto(&out.NodeSelector)
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]Route, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteOverride.
+func (in *RouteOverride) DeepCopy() *RouteOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(RouteOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingTable) DeepCopyInto(out *RoutingTable) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingTable.
+func (in *RoutingTable) DeepCopy() *RoutingTable {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingTable)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RoutingTable) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingTableList) DeepCopyInto(out *RoutingTableList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RoutingTable, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingTableList.
+func (in *RoutingTableList) DeepCopy() *RoutingTableList {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingTableList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RoutingTableList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingTableSpec) DeepCopyInto(out *RoutingTableSpec) {
+	*out = *in
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]Route, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeOverrides != nil {
+		in, out := &in.NodeOverrides, &out.NodeOverrides
+		*out = make([]RouteOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingTableSpec.
+func (in *RoutingTableSpec) DeepCopy() *RoutingTableSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingTableSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutingTableStatus) DeepCopyInto(out *RoutingTableStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeRuleStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutingTableStatus.
+func (in *RoutingTableStatus) DeepCopy() *RoutingTableStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutingTableStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleAction) DeepCopyInto(out *RuleAction) {
+	*out = *in
+	if in.SuppressPrefixLength != nil {
+		in, out := &in.SuppressPrefixLength, &out.SuppressPrefixLength
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleAction.
+func (in *RuleAction) DeepCopy() *RuleAction {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RuleSelector) DeepCopyInto(out *RuleSelector) {
+	*out = *in
+	if in.FwMark != nil {
+		in, out := &in.FwMark, &out.FwMark
+		*out = new(int64)
+		**out = **in
+	}
+	if in.FwMask != nil {
+		in, out := &in.FwMask, &out.FwMask
+		*out = new(int64)
+		**out = **in
+	}
+	if in.SrcPort != nil {
+		in, out := &in.SrcPort, &out.SrcPort
+		*out = new(PortRange)
+		**out = **in
+	}
+	if in.DstPort != nil {
+		in, out := &in.DstPort, &out.DstPort
+		*out = new(PortRange)
+		**out = **in
+	}
+	if in.UIDRange != nil {
+		in, out := &in.UIDRange, &out.UIDRange
+		*out = new(UIDRange)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuleSelector.
+func (in *RuleSelector) DeepCopy() *RuleSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(RuleSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UIDRange) DeepCopyInto(out *UIDRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UIDRange.
+func (in *UIDRange) DeepCopy() *UIDRange {
+	if in == nil {
+		return nil
+	}
+	out := new(UIDRange)
+	in.DeepCopyInto(out)
+	return out
+}
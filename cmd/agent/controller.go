@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// ruleReconciler applies IPRuleConfigs and RoutingTables to the host as soon as the informers
+// observe a change. Every watched event maps to the same (empty) request, so bursts of events
+// collapse into a single run. Rule and route deletions on the host arrive through RuleEvents
+// (see ruleMonitor); ResyncPeriod only serves as slow drift correction on top of that.
+type ruleReconciler struct {
+	client.Client
+	NodeName     string
+	ResyncPeriod time.Duration
+	RuleEvents   <-chan event.GenericEvent
+	// Recorder emits the rule lifecycle events on the IPRuleConfigs and this node.
+	Recorder record.EventRecorder
+	// Audit only reports the changes the agent would make (AGENT_MODE=audit): rules and routes
+	// are never added or deleted, rt_tables is not written and absent configs are not acked.
+	Audit bool
+	// Pool is the spec.agentPool of the IPRuleConfigs this agent applies, empty for the default
+	// pool. Rules of other pools are orphans on this node and garbage-collected.
+	Pool string
+	// IPRoute2Dir is the host's iproute2 configuration directory the RoutingTable names are
+	// registered in. Empty disables the registration.
+	IPRoute2Dir string
+
+	// applied holds the keys of desired rules this agent has seen in place. It is only touched
+	// from Reconcile, which never runs concurrently for the single queue key.
+	applied map[string]struct{}
+	// synced is set after the first sync without error; the readiness check waits for it.
+	synced atomic.Bool
+}
+
+func (r *ruleReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	timer := prometheus.NewTimer(metricReconcileDuration)
+	defer timer.ObserveDuration()
+
+	// Routes first, so new rules do not point into a table that is still empty. A failure in
+	// one must not hold back the other.
+	if err := errors.Join(r.reconcileRoutes(ctx), r.reconcileOnce(ctx)); err != nil {
+		return ctrl.Result{}, err
+	}
+	metricLastSuccessfulSync.SetToCurrentTime()
+	r.synced.Store(true)
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// readyCheck fails until the host was synced once, so a restarting agent only turns ready
+// (and is waited for by the absent-config cleanup) once its rules are in place.
+func (r *ruleReconciler) readyCheck(_ *http.Request) error {
+	if !r.synced.Load() {
+		return errors.New("initial sync pending")
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ruleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Global reconcile (we ignore the specific request key inside Reconcile)
+	enqueueAll := handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+		return []reconcile.Request{{}}
+	})
+	r.applied = map[string]struct{}{}
+	return ctrl.NewControllerManagedBy(mgr).
+		// Status writes of the other agents must not wake up every agent
+		Watches(&apiv1alpha1.IPRuleConfig{}, enqueueAll, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&apiv1alpha1.RoutingTable{}, enqueueAll, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Node labels select the RoutingTable overrides
+		Watches(&corev1.Node{}, enqueueAll, builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		WatchesRawSource(source.Channel(r.RuleEvents, enqueueAll)).
+		Named("iprule-agent").
+		Complete(r)
+}
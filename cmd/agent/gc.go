@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// managedRuleProtocol is stamped into every rule the agent creates (FRA_PROTOCOL, shown as
+// "proto 241" by `ip rule`). It is unassigned in /etc/iproute2/rt_protos and lets the agent
+// recognise its own rules after restarts without any local state.
+const managedRuleProtocol uint8 = 241
+
+// deleteOrphanRules removes rules owned by the agent that no longer have a matching present
+// IPRuleConfig, e.g. because the config was deleted while the agent was down. Rules created
+// before the protocol marking was introduced are not touched. It returns the number of orphans
+// that could not be deleted.
+func deleteOrphanRules(ctx context.Context, owned []netlink.Rule, desired map[string]bool) (remaining int) {
+	log := logf.FromContext(ctx)
+	for i := range owned {
+		rl := owned[i]
+		if desired[ruleKey(&rl)] {
+			continue
+		}
+		if err := netlink.RuleDel(&rl); err != nil {
+			if errors.Is(err, unix.ENOENT) { // already removed by handleAbsentConfig in this run
+				continue
+			}
+			countNetlinkError("rule_del", err)
+			log.Error(err, "delete orphaned ip rule failed", "rule", rl.String())
+			remaining++
+			continue
+		}
+		metricOrphanRulesDeleted.Inc()
+		log.Info("deleted orphaned ip rule", "rule", rl.String())
+	}
+	return remaining
+}
+
+// auditOrphanRules logs the rules deleteOrphanRules would delete and returns their number.
+func auditOrphanRules(ctx context.Context, owned []netlink.Rule, desired map[string]bool) (orphans int) {
+	log := logf.FromContext(ctx)
+	for i := range owned {
+		if desired[ruleKey(&owned[i])] {
+			continue
+		}
+		orphans++
+		log.Info("audit: would delete orphaned ip rule", "rule", owned[i].String())
+	}
+	return orphans
+}
@@ -6,57 +6,54 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
 	"math"
 	"net"
+	"net/netip"
 	"os"
 	"time"
 
 	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
 
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 )
 
-// ruleEntry represents a desired ip rule from node annotation
-// {"ip":"1.2.3.4","table":100,"priority":1000}
-// priority is optional
-// The agent will ensure rules exist in the host network namespace (hostNetwork pod)
-
-// ruleEntry stays for debug output / compatibility (annotation fallback)
-type ruleEntry struct {
-	IP       string `json:"ip"`
-	Table    int    `json:"table"`
-	Priority int    `json:"priority,omitempty"`
-}
-
-const (
-	// Ack Annotation Prefix pro Node
-	annotationCleanupPrefix = "cleanup.iprule.agent.brtrm.dev/" // + <nodeName>
-	ackValueDone            = "done"
-)
+var setupLog = ctrl.Log.WithName("setup")
 
 func main() {
-	cfg := ctrl.GetConfigOrDie()
+	var opts zap.Options
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
 	// Extend scheme
 	scheme := runtime.NewScheme()
 	if err := apiv1alpha1.AddToScheme(scheme); err != nil {
-		log.Fatalf("add scheme: %v", err)
+		setupLog.Error(err, "add scheme")
+		os.Exit(1)
 	}
 	if err := corev1.AddToScheme(scheme); err != nil {
-		log.Fatalf("add corev1 scheme: %v", err)
-	}
-	c, err := client.New(cfg, client.Options{Scheme: scheme})
-	if err != nil {
-		log.Fatalf("failed to init k8s client: %v", err)
+		setupLog.Error(err, "add corev1 scheme")
+		os.Exit(1)
 	}
 
-	// NodeName bestimmen (Downward API env: NODE_NAME oder Hostname)
+	// Determine the node name (Downward API env NODE_NAME, falling back to the hostname)
 	nodeName := getEnvString("NODE_NAME", "")
 	if nodeName == "" {
 		if hn, err := os.Hostname(); err == nil {
@@ -64,18 +61,83 @@ func main() {
 		}
 	}
 	if nodeName == "" {
-		log.Printf("WARN: NODE_NAME nicht gesetzt; koordinierte Löschung deaktiviert")
+		setupLog.Info("NODE_NAME not set; node status reporting disabled")
 	}
 
-	log.Printf("starting iprule-agent (IPRuleConfig mode) on node %s", nodeName)
-
-	ctx := context.Background()
-	interval := getEnvDuration("RECONCILE_PERIOD", 10*time.Second)
-	for {
-		if err := reconcileOnce(ctx, c, nodeName); err != nil {
-			log.Printf("reconcile error: %v", err)
+	// The agent only needs its own Node; caching all of them would cost every agent a full scan.
+	var cacheOpts cache.Options
+	if nodeName != "" {
+		cacheOpts.ByObject = map[client.Object]cache.ByObject{
+			&corev1.Node{}: {Field: fields.OneTermEqualSelector("metadata.name", nodeName)},
 		}
-		time.Sleep(interval)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme: scheme,
+		Cache:  cacheOpts,
+		// The agent runs with hostNetwork on every node; keep all listeners off unless
+		// explicitly requested so we never collide with ports of host services. The DaemonSet
+		// built by the operator enables both.
+		Metrics:                metricsserver.Options{BindAddress: getEnvString("METRICS_BIND_ADDRESS", "0")},
+		HealthProbeBindAddress: getEnvString("HEALTH_PROBE_BIND_ADDRESS", "0"),
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create manager")
+		os.Exit(1)
+	}
+
+	// Kernel rule deletions are fed into the controller through this channel.
+	ruleEvents := make(chan event.GenericEvent, 1)
+	if err := mgr.Add(&ruleMonitor{events: ruleEvents}); err != nil {
+		setupLog.Error(err, "unable to add rule monitor")
+		os.Exit(1)
+	}
+
+	var audit bool
+	switch mode := getEnvString("AGENT_MODE", string(apiv1alpha1.AgentModeEnforce)); apiv1alpha1.AgentMode(mode) {
+	case apiv1alpha1.AgentModeEnforce:
+	case apiv1alpha1.AgentModeAudit:
+		audit = true
+		setupLog.Info("audit mode: planned changes are only reported, the host is not modified")
+	default:
+		setupLog.Error(fmt.Errorf("unknown mode %q", mode), "invalid AGENT_MODE")
+		os.Exit(1)
+	}
+
+	// IPRuleConfigs of the default pool leave spec.agentPool empty
+	poolName := getEnvString("AGENT_POOL", apiv1alpha1.DefaultAgentPool)
+	pool := poolName
+	if pool == apiv1alpha1.DefaultAgentPool {
+		pool = ""
+	}
+
+	reconciler := &ruleReconciler{
+		Client:       mgr.GetClient(),
+		NodeName:     nodeName,
+		ResyncPeriod: getEnvDuration("RECONCILE_PERIOD", 5*time.Minute),
+		RuleEvents:   ruleEvents,
+		Recorder:     mgr.GetEventRecorderFor("iprule-agent"),
+		IPRoute2Dir:  getEnvString("IPROUTE2_DIR", "/etc/iproute2"),
+		Audit:        audit,
+		Pool:         pool,
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", reconciler.readyCheck); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting iprule-agent", "node", nodeName, "pool", poolName)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running agent")
+		os.Exit(1)
 	}
 }
 
@@ -98,66 +160,162 @@ func getEnvDuration(k string, def time.Duration) time.Duration {
 	return d
 }
 
-func reconcileOnce(ctx context.Context, c client.Client, nodeName string) error {
-	// List all IPRuleConfigs (cluster-scoped)
+// reconcileOnce converges the host rules towards the IPRuleConfigs held in the informer cache.
+func (r *ruleReconciler) reconcileOnce(ctx context.Context) error {
+	log := logf.FromContext(ctx)
+	// List all IPRuleConfigs (cluster-scoped), served from the informer cache
 	cfgList := &apiv1alpha1.IPRuleConfigList{}
-	if err := c.List(ctx, cfgList, &client.ListOptions{}); err != nil {
+	if err := r.List(ctx, cfgList, &client.ListOptions{}); err != nil {
 		return fmt.Errorf("list IPRuleConfigs: %w", err)
 	}
+	nodeLabels, err := r.nodeLabels(ctx)
+	if err != nil {
+		return err
+	}
+	// Configs of other pools or not selecting this node are skipped: their rules are orphans here,
+	// and the controller does not wait for this node to acknowledge their removal.
+	// Present configs go first: several configs may carry the same rule (e.g. one renamed by the
+	// controller), and an absent one must not remove a rule a present one still wants.
 	filtered := make([]*apiv1alpha1.IPRuleConfig, 0, len(cfgList.Items))
+	var absent []*apiv1alpha1.IPRuleConfig
 	for i := range cfgList.Items {
 		cfg := &cfgList.Items[i]
-		if cfg.Labels["managed-by"] != "ip-rule-operator" {
+		if cfg.Labels["managed-by"] != "ip-rule-operator" || cfg.Spec.AgentPool != r.Pool ||
+			!selectsNode(cfg.Spec.NodeSelector, nodeLabels) {
 			continue
 		}
-		filtered = append(filtered, cfg)
+		if cfg.Spec.State == apiv1alpha1.StatePresent {
+			filtered = append(filtered, cfg)
+		} else {
+			absent = append(absent, cfg)
+		}
 	}
+	filtered = append(filtered, absent...)
 	// Build rule index once
-	ruleIndex, err := buildRuleIndex()
+	ruleIndex, owned, err := buildRuleIndex()
 	if err != nil {
 		return err
 	}
+	// Keys of all rules that still have a present IPRuleConfig; everything else we own is an orphan.
+	desired := make(map[string]bool, len(filtered))
+	managed, plannedAdds, plannedDeletes := 0, 0, 0
 	for _, cfg := range filtered {
-		ip := cfg.Spec.ServiceIP
-		table := cfg.Spec.Table
-		prio := cfg.Spec.Priority
-		if ip == "" || table == 0 {
+		rule, ruleErr := desiredRule(&cfg.Spec)
+		if cfg.Spec.State == apiv1alpha1.StatePresent {
+			if ruleErr != nil {
+				r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateFailed, ruleErr.Error())
+				continue
+			}
+			key := ruleKey(rule)
+			desired[key] = true
+			if ruleIndex[key] {
+				managed++
+				r.applied[key] = struct{}{}
+				r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateApplied, "")
+				continue
+			}
+			if r.Audit {
+				plannedAdds++
+				log.Info("audit: would add ip rule", "config", cfg.Name, "rule", rule.String())
+				r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateWouldAdd, "")
+				continue
+			}
+			// A rule we already had in place vanished from the host: someone removed it out-of-band.
+			_, repair := r.applied[key]
+			if err := addRuleWithRetry(rule); err != nil {
+				log.Error(err, "add rule failed after retries", "config", cfg.Name, "rule", rule.String())
+				r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateFailed, err.Error())
+				continue
+			}
+			managed++
+			// Configs of IPRules with different node selectors may carry the same rule
+			ruleIndex[key] = true
+			r.applied[key] = struct{}{}
+			r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateApplied, "")
+			metricRulesAdded.Inc()
+			if repair {
+				metricRulesRepaired.Inc()
+				log.Info("repaired ip rule removed out-of-band", "config", cfg.Name, "rule", rule.String())
+				r.ruleEvent(cfg, corev1.EventTypeWarning, "RuleRepaired", "Re-added rule removed out-of-band: "+rule.String())
+			} else {
+				log.Info("added ip rule", "config", cfg.Name, "rule", rule.String())
+				r.ruleEvent(cfg, corev1.EventTypeNormal, "RuleAdded", "Added rule "+rule.String())
+			}
 			continue
 		}
-		keyExact := ruleKey(ip, table, prio)
+		// An absent config that does not translate into a rule never had one on this node; one
+		// whose rule a present config still wants only acknowledges the removal.
 		present := false
-		if prio > 0 {
-			_, present = ruleIndex[keyExact]
-		} else {
-			present = ruleIndex[ruleKey(ip, table, 0)] || ruleIndex[ruleKey(ip, table, -1)]
+		if ruleErr == nil {
+			key := ruleKey(rule)
+			present = ruleIndex[key] && !desired[key]
 		}
-
-		if cfg.Spec.State == apiv1alpha1.StatePresent {
+		if r.Audit {
+			// Never ack: the controller would delete the config while the rule is still in place.
 			if present {
-				continue
-			}
-			if err := addRuleWithRetry(ruleEntry{IP: ip, Table: table, Priority: prio}); err != nil {
-				log.Printf("add rule failed after retries (%s table %d prio %d): %v", ip, table, prio, err)
-			} else {
-				log.Printf("added ip rule: from %s lookup table %d priority %d", ip, table, prio)
+				plannedDeletes++
+				log.Info("audit: would delete ip rule (absent)", "config", cfg.Name, "rule", rule.String())
+				r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateWouldRemove, "")
 			}
 			continue
 		}
-		if err := handleAbsentConfig(ctx, c, cfg, nodeName, present); err != nil {
-			log.Printf("handleAbsentConfig %s failed: %v", cfg.Name, err)
+		r.handleAbsentConfig(ctx, cfg, rule, present)
+	}
+	if r.Audit {
+		orphans := auditOrphanRules(ctx, owned, desired)
+		managed += orphans
+		metricAuditPlanned.WithLabelValues("rule", "add").Set(float64(plannedAdds))
+		metricAuditPlanned.WithLabelValues("rule", "delete").Set(float64(plannedDeletes + orphans))
+	} else {
+		managed += deleteOrphanRules(ctx, owned, desired)
+	}
+	metricManagedRules.Set(float64(managed))
+	// Forget the rules of deleted, changed or deselected configs, so a rule that comes back later
+	// is added again instead of being counted as repaired.
+	for key := range r.applied {
+		if !desired[key] {
+			delete(r.applied, key)
 		}
 	}
 	return nil
 }
 
-// buildRuleIndex reads rules once and builds an index
-func buildRuleIndex() (map[string]bool, error) {
-	rules, err := netlink.RuleList(netlink.FAMILY_ALL)
+// nodeLabels returns the labels of the node of this agent, nil if the node name is unknown.
+func (r *ruleReconciler) nodeLabels(ctx context.Context) (map[string]string, error) {
+	if r.NodeName == "" {
+		return nil, nil
+	}
+	node := &corev1.Node{}
+	if err := r.Get(ctx, client.ObjectKey{Name: r.NodeName}, node); err != nil {
+		return nil, fmt.Errorf("get node %s: %w", r.NodeName, err)
+	}
+	return node.Labels, nil
+}
+
+// selectsNode reports whether the node selector of an IPRuleConfig selects a node with
+// nodeLabels. Unset selects every node; an invalid selector (rejected by the webhook) none.
+func selectsNode(sel *metav1.LabelSelector, nodeLabels map[string]string) bool {
+	if sel == nil {
+		return true
+	}
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	return err == nil && s.Matches(labels.Set(nodeLabels))
+}
+
+// buildRuleIndex reads rules once and builds an index. Rules carrying managedRuleProtocol are
+// additionally returned as owned so orphans can be garbage-collected.
+func buildRuleIndex() (map[string]bool, []netlink.Rule, error) {
+	rules, err := listRules()
 	if err != nil {
-		return nil, fmt.Errorf("list rules: %w", err)
+		countNetlinkError("rule_list", err)
+		return nil, nil, fmt.Errorf("list rules: %w", err)
 	}
 	idx := make(map[string]bool, len(rules))
+	var owned []netlink.Rule
 	for _, rl := range rules {
+		if rl.Protocol == managedRuleProtocol {
+			owned = append(owned, rl)
+		}
 		if rl.Src == nil {
 			continue
 		}
@@ -166,26 +324,203 @@ func buildRuleIndex() (map[string]bool, error) {
 		if (bits == 32 && ones != 32) || (bits == 128 && ones != 128) {
 			continue
 		}
-		ip := rl.Src.IP.String()
-		prio := rl.Priority
-		// Wildcard entry (priority agnostic) – wichtig damit Konfigs ohne Priority (0)
-		// nicht ständig neue Regeln erzeugen, weil der Kernel beim Hinzufügen eine
-		// eigene Priority vergibt (≠0) und wir sonst die Präsenz nicht erkennen.
-		idx[ruleKey(ip, rl.Table, -1)] = true
-		// Konkrete Priority festhalten
-		idx[ruleKey(ip, rl.Table, prio)] = true
-	}
-	return idx, nil
+		idx[ruleKey(&rl)] = true
+	}
+	return idx, owned, nil
 }
 
-func ruleKey(ip string, table, prio int) string { return fmt.Sprintf("%s|%d|%d", ip, table, prio) }
+// ruleKey identifies a rule by its source, table, priority, action and every selector the agent
+// manages, so config and kernel spellings of the same rule produce the same key and a changed
+// selector or action is a different rule.
+func ruleKey(rl *netlink.Rule) string {
+	src := ""
+	if rl.Src != nil {
+		src = canonicalIP(rl.Src.IP.String())
+	}
+	dst := ""
+	if rl.Dst != nil {
+		dst = rl.Dst.String()
+	}
+	// The kernel applies a full mask to a mark given without one and only reports the mask of
+	// rules that have a mark or mask.
+	var mask uint32
+	if rl.Mask != nil {
+		mask = *rl.Mask
+	} else if rl.Mark != 0 {
+		mask = math.MaxUint32
+	}
+	key := fmt.Sprintf("%s|%d|%d|to=%s|fwmark=%#x/%#x|iif=%s|oif=%s|tos=%d|ipproto=%d",
+		src, rl.Table, rl.Priority, dst, rl.Mark, mask, rl.IifName, rl.OifName, rl.Tos, rl.IPProto)
+	if rl.Sport != nil {
+		key += fmt.Sprintf("|sport=%d-%d", rl.Sport.Start, rl.Sport.End)
+	}
+	if rl.Dport != nil {
+		key += fmt.Sprintf("|dport=%d-%d", rl.Dport.Start, rl.Dport.End)
+	}
+	if rl.UIDRange != nil {
+		key += fmt.Sprintf("|uidrange=%d-%d", rl.UIDRange.Start, rl.UIDRange.End)
+	}
+	if rl.Invert {
+		key += "|not"
+	}
+	key += fmt.Sprintf("|action=%d", rl.Type)
+	if rl.Goto >= 0 {
+		key += fmt.Sprintf("|goto=%d", rl.Goto)
+	}
+	if rl.SuppressPrefixlen >= 0 {
+		key += fmt.Sprintf("|suppress_prefixlength=%d", rl.SuppressPrefixlen)
+	}
+	return key
+}
+
+// ipProtoNumbers maps the IPProtocol names of the API to their protocol numbers.
+var ipProtoNumbers = map[apiv1alpha1.IPProtocol]int{
+	apiv1alpha1.IPProtocolTCP:    unix.IPPROTO_TCP,
+	apiv1alpha1.IPProtocolUDP:    unix.IPPROTO_UDP,
+	apiv1alpha1.IPProtocolSCTP:   unix.IPPROTO_SCTP,
+	apiv1alpha1.IPProtocolICMP:   unix.IPPROTO_ICMP,
+	apiv1alpha1.IPProtocolICMPv6: unix.IPPROTO_ICMPV6,
+}
+
+// desiredRule translates an IPRuleConfig spec into the netlink rule the agent maintains.
+func desiredRule(spec *apiv1alpha1.IPRuleConfigSpec) (*netlink.Rule, error) {
+	// The operator always sets table and priority; a config without them was created by hand or
+	// by an older operator version and would leave the priority to the kernel.
+	if spec.ServiceIP == "" || (spec.Table == 0 && spec.VRF == "") || spec.Priority == 0 {
+		return nil, errors.New("serviceIP, table and priority must be set")
+	}
+	src, err := ipToNet(spec.ServiceIP)
+	if err != nil {
+		return nil, err
+	}
+	rule := netlink.NewRule()
+	rule.Family = ipFamily(src)
+	rule.Src = src
+	rule.Priority = spec.Priority
+	rule.Protocol = managedRuleProtocol
+
+	sel := &spec.RuleSelector
+	if sel.FwMark != nil {
+		rule.Mark = uint32(*sel.FwMark)
+		mask := uint32(math.MaxUint32)
+		if sel.FwMask != nil {
+			mask = uint32(*sel.FwMask)
+		}
+		rule.Mask = &mask
+	}
+	rule.IifName = sel.IIF
+	rule.OifName = sel.OIF
+	if sel.Dst != "" {
+		dst, err := netip.ParsePrefix(sel.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dst: %w", err)
+		}
+		dst = dst.Masked()
+		addr := dst.Addr().Unmap()
+		if addr.Is4() != (rule.Family == netlink.FAMILY_V4) {
+			return nil, fmt.Errorf("dst %s does not match the family of %s", sel.Dst, spec.ServiceIP)
+		}
+		rule.Dst = &net.IPNet{IP: addr.AsSlice(), Mask: net.CIDRMask(dst.Bits(), addr.BitLen())}
+	}
+	rule.Tos = uint(sel.TOS)
+	if sel.IPProto != "" {
+		proto, ok := ipProtoNumbers[sel.IPProto]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ipProto %q", sel.IPProto)
+		}
+		rule.IPProto = proto
+	}
+	rule.Sport = portRange(sel.SrcPort)
+	rule.Dport = portRange(sel.DstPort)
+	// The full range is the kernel's "unset" and is not reported back, so it is not set either.
+	if u := sel.UIDRange; u != nil && (u.Start != 0 || u.End != math.MaxUint32) {
+		rule.UIDRange = netlink.NewRuleUIDRange(uint32(u.Start), uint32(u.End))
+	}
+	rule.Invert = sel.Invert
+
+	// Only lookup rules reference a table; the kernel reports table 0 for all others.
+	if spec.VRF != "" && spec.Action != "" && spec.Action != apiv1alpha1.RuleActionLookup {
+		return nil, errors.New("vrf requires the Lookup action")
+	}
+	switch spec.Action {
+	case "", apiv1alpha1.RuleActionLookup:
+		rule.Type = nl.FR_ACT_TO_TBL
+		rule.Table = spec.Table
+		if spec.VRF != "" {
+			table, err := vrfTable(spec.VRF)
+			if err != nil {
+				return nil, err
+			}
+			rule.Table = table
+		}
+		if spec.SuppressPrefixLength != nil {
+			// netlink only sends the attribute for tables that fit the rule header
+			if rule.Table > 255 {
+				return nil, errors.New("suppressPrefixLength requires a table below 256")
+			}
+			rule.SuppressPrefixlen = int(*spec.SuppressPrefixLength)
+		}
+	case apiv1alpha1.RuleActionGoto:
+		if spec.GotoPriority <= int64(spec.Priority) {
+			return nil, errors.New("gotoPriority must be higher than priority")
+		}
+		rule.Type = nl.FR_ACT_GOTO
+		rule.Goto = int(spec.GotoPriority)
+	case apiv1alpha1.RuleActionBlackhole:
+		rule.Type = nl.FR_ACT_BLACKHOLE
+	case apiv1alpha1.RuleActionUnreachable:
+		rule.Type = nl.FR_ACT_UNREACHABLE
+	case apiv1alpha1.RuleActionProhibit:
+		rule.Type = nl.FR_ACT_PROHIBIT
+	default:
+		return nil, fmt.Errorf("unsupported action %q", spec.Action)
+	}
+	return rule, nil
+}
+
+// vrfTable returns the table of the VRF device name on this node. It is the table the kernel's
+// l3mdev rule selects for traffic already bound to the VRF, so a service rule pointing into it
+// treats the service traffic as if it belonged to the VRF.
+func vrfTable(name string) (int, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("vrf %s: %w", name, err)
+	}
+	vrf, ok := link.(*netlink.Vrf)
+	if !ok {
+		return 0, fmt.Errorf("device %s is a %s, not a vrf", name, link.Type())
+	}
+	return int(vrf.Table), nil
+}
+
+// portRange converts an API port range; a missing end matches the start port only.
+func portRange(p *apiv1alpha1.PortRange) *netlink.RulePortRange {
+	if p == nil {
+		return nil
+	}
+	end := p.End
+	if end == 0 {
+		end = p.Start
+	}
+	return netlink.NewRulePortRange(uint16(p.Start), uint16(end))
+}
+
+// canonicalIP normalises an address so config and kernel spellings of the same IPv6 address
+// ("FD00::0010" vs "fd00::10") produce the same rule key. Unparsable input is returned as is.
+func canonicalIP(s string) string {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return s
+	}
+	return ip.Unmap().String()
+}
 
 // Retry Helpers
-func addRuleWithRetry(r ruleEntry) error {
-	return retry(3, 150*time.Millisecond, func() error { return addRule(r) })
+func addRuleWithRetry(rule *netlink.Rule) error {
+	return retry(3, 150*time.Millisecond, func() error { return addRule(rule) })
 }
-func delRuleWithRetry(r ruleEntry) error {
-	return retry(3, 150*time.Millisecond, func() error { return delRule(r) })
+func delRuleWithRetry(rule *netlink.Rule) error {
+	return retry(3, 150*time.Millisecond, func() error { return delRule(rule) })
 }
 
 func retry(attempts int, baseDelay time.Duration, fn func() error) error {
@@ -205,59 +540,32 @@ func retry(attempts int, baseDelay time.Duration, fn func() error) error {
 	return err
 }
 
-func addRule(r ruleEntry) error {
-	ipNet, err := ipToNet(r.IP)
-	if err != nil {
-		return err
-	}
-	rule := netlink.NewRule()
-	rule.Src = ipNet
-	rule.Table = r.Table
-	if r.Priority > 0 {
-		rule.Priority = r.Priority
-	}
+func addRule(rule *netlink.Rule) error {
 	if err := netlink.RuleAdd(rule); err != nil {
 		// Ignore EEXIST
 		if os.IsExist(err) {
 			return nil
 		}
-		return fmt.Errorf("RuleAdd failed for %s table %d: %w", r.IP, r.Table, err)
+		countNetlinkError("rule_add", err)
+		return fmt.Errorf("RuleAdd failed for %s: %w", rule.String(), err)
 	}
 	return nil
 }
 
-func delRule(r ruleEntry) error {
-	ipNet, err := ipToNet(r.IP)
-	if err != nil {
-		return err
-	}
-	// Try first with priority (if set), then without
-	attempts := []netlink.Rule{}
-	with := netlink.NewRule()
-	with.Src = ipNet
-	with.Table = r.Table
-	if r.Priority > 0 {
-		with.Priority = r.Priority
-	}
-	attempts = append(attempts, *with)
-	if r.Priority > 0 { // fallback without priority
-		noPrio := netlink.NewRule()
-		noPrio.Src = ipNet
-		noPrio.Table = r.Table
-		attempts = append(attempts, *noPrio)
-	}
+func delRule(rule *netlink.Rule) error {
+	// Try first with priority, then without
+	noPrio := *rule
+	noPrio.Priority = -1
 	var lastErr error
-	for _, rl := range attempts {
+	for _, rl := range []netlink.Rule{*rule, noPrio} {
 		if err := netlink.RuleDel(&rl); err != nil {
+			countNetlinkError("rule_del", err)
 			lastErr = err
 			continue
 		}
 		return nil
 	}
-	if lastErr != nil {
-		return fmt.Errorf("RuleDel failed for %s table %d: %w", r.IP, r.Table, lastErr)
-	}
-	return nil
+	return fmt.Errorf("RuleDel failed for %s: %w", rule.String(), lastErr)
 }
 
 func ipToNet(ipStr string) (*net.IPNet, error) {
@@ -265,181 +573,34 @@ func ipToNet(ipStr string) (*net.IPNet, error) {
 	if ip == nil {
 		return nil, fmt.Errorf("invalid ip: %s", ipStr)
 	}
-	if ip.To4() != nil { // IPv4
-		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, nil
+	if ip4 := ip.To4(); ip4 != nil { // IPv4
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
 	}
 	// IPv6
 	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
 }
 
-// deleteIPRuleConfigWithRetry löscht ein IPRuleConfig Objekt robust (Konflikte/NotFound tolerant)
-func deleteIPRuleConfigWithRetry(ctx context.Context, c client.Client, cfg *apiv1alpha1.IPRuleConfig) error {
-	return retry(3, 100*time.Millisecond, func() error {
-		// Fresh fetch to avoid stale UID conflicts
-		fresh := &apiv1alpha1.IPRuleConfig{}
-		if err := c.Get(ctx, client.ObjectKey{Name: cfg.Name}, fresh); err != nil {
-			// Already gone
-			if client.IgnoreNotFound(err) == nil {
-				return nil
-			}
-			return err
-		}
-		if fresh.Spec.State != "absent" { // someone changed it back -> abort without error
-			return nil
-		}
-		if err := c.Delete(ctx, fresh); err != nil {
-			return err
-		}
-		return nil
-	})
-}
-
-// getTargetNodes gibt die Nodes zurück, auf denen der Agent läuft (basierend auf Agent NodeSelector).
-// Falls kein Agent CR existiert oder kein NodeSelector gesetzt ist, werden alle Nodes zurückgegeben.
-func getTargetNodes(ctx context.Context, c client.Client) ([]corev1.Node, error) {
-	// List all Agent CRs
-	agentList := &apiv1alpha1.AgentList{}
-	if err := c.List(ctx, agentList); err != nil {
-		return nil, fmt.Errorf("list agents: %w", err)
-	}
-
-	// If no Agent CR exists, return all nodes (fallback to old behavior)
-	if len(agentList.Items) == 0 {
-		return getAllNodes(ctx, c)
-	}
-
-	// Use the first Agent CR's nodeSelector
-	agent := &agentList.Items[0]
-	nodeSelector := agent.Spec.NodeSelector
-
-	// If no nodeSelector is set, return all nodes
-	if len(nodeSelector) == 0 {
-		return getAllNodes(ctx, c)
+// ipFamily returns the netlink family of a host prefix built by ipToNet.
+func ipFamily(ipNet *net.IPNet) int {
+	if len(ipNet.IP) == net.IPv4len {
+		return netlink.FAMILY_V4
 	}
-
-	// List nodes matching the nodeSelector
-	nodeList := &corev1.NodeList{}
-	if err := c.List(ctx, nodeList); err != nil {
-		return nil, fmt.Errorf("list nodes: %w", err)
-	}
-
-	// Filter nodes by nodeSelector
-	var targetNodes []corev1.Node
-	for i := range nodeList.Items {
-		node := &nodeList.Items[i]
-		if matchesNodeSelector(node, nodeSelector) {
-			targetNodes = append(targetNodes, *node)
-		}
-	}
-
-	return targetNodes, nil
-}
-
-// getAllNodes gibt alle Nodes im Cluster zurück
-func getAllNodes(ctx context.Context, c client.Client) ([]corev1.Node, error) {
-	nodeList := &corev1.NodeList{}
-	if err := c.List(ctx, nodeList); err != nil {
-		return nil, fmt.Errorf("list all nodes: %w", err)
-	}
-	return nodeList.Items, nil
+	return netlink.FAMILY_V6
 }
 
-// matchesNodeSelector prüft, ob ein Node alle Labels des NodeSelectors hat
-func matchesNodeSelector(node *corev1.Node, selector map[string]string) bool {
-	for key, value := range selector {
-		nodeValue, exists := node.Labels[key]
-		if !exists || nodeValue != value {
-			return false
-		}
-	}
-	return true
-}
-
-// handleAbsentConfig löscht lokale Rule, setzt Ack-Annotation und löscht CR erst wenn alle Nodes bestätigt haben.
-func handleAbsentConfig(
-	ctx context.Context,
-	c client.Client,
-	cfg *apiv1alpha1.IPRuleConfig,
-	nodeName string,
-	rulePresent bool,
-) error {
-	if nodeName == "" { // no coordination possible without node name
-		return nil
-	}
-	if cfg.Spec.State != apiv1alpha1.StateAbsent { // nur absent behandeln
-		return nil
-	}
-	ip := cfg.Spec.ServiceIP
-	table := cfg.Spec.Table
-	prio := cfg.Spec.Priority
+// handleAbsentConfig removes the local rule of an absent IPRuleConfig and acknowledges the removal
+// with a Removed entry in status.nodes. The controller deletes the config once every node did.
+func (r *ruleReconciler) handleAbsentConfig(ctx context.Context, cfg *apiv1alpha1.IPRuleConfig, rule *netlink.Rule, rulePresent bool) {
+	log := logf.FromContext(ctx)
 	if rulePresent {
-		if err := delRuleWithRetry(ruleEntry{IP: ip, Table: table, Priority: prio}); err != nil {
-			return fmt.Errorf("delete rule: %w", err)
-		}
-		log.Printf("deleted ip rule (absent): from %s lookup table %d priority %d", ip, table, prio)
-	}
-	ackKey := annotationCleanupPrefix + nodeName
-	// Ack setzen (mit Retry für Konflikte)
-	if err := retry(5, 120*time.Millisecond, func() error {
-		fresh := &apiv1alpha1.IPRuleConfig{}
-		if err := c.Get(ctx, client.ObjectKey{Name: cfg.Name}, fresh); err != nil {
-			if client.IgnoreNotFound(err) == nil { // schon gelöscht
-				return nil
-			}
-			return err
-		}
-		if fresh.Spec.State != apiv1alpha1.StateAbsent { // wieder aktiv
-			return nil
-		}
-		if fresh.Annotations == nil {
-			fresh.Annotations = map[string]string{}
-		}
-		if fresh.Annotations[ackKey] == ackValueDone { // bereits ack
-			return nil
-		}
-		fresh.Annotations[ackKey] = ackValueDone
-		if err := c.Update(ctx, fresh); err != nil {
-			if apierrors.IsConflict(err) { // retry
-				return err
-			}
-			return err
+		if err := delRuleWithRetry(rule); err != nil {
+			log.Error(err, "delete rule failed after retries", "config", cfg.Name, "rule", rule.String())
+			r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateFailed, err.Error())
+			return
 		}
-		return nil
-	}); err != nil {
-		return fmt.Errorf("set ack annotation failed: %w", err)
-	}
-	// Prüfen ob alle Nodes ge-acked haben
-	fresh := &apiv1alpha1.IPRuleConfig{}
-	if err := c.Get(ctx, client.ObjectKey{Name: cfg.Name}, fresh); err != nil {
-		if client.IgnoreNotFound(err) == nil { // schon gelöscht
-			return nil
-		}
-		return err
-	}
-	if fresh.Spec.State != apiv1alpha1.StateAbsent { // resurrected
-		return nil
+		metricRulesDeleted.Inc()
+		log.Info("deleted ip rule (absent)", "config", cfg.Name, "rule", rule.String())
+		r.ruleEvent(cfg, corev1.EventTypeNormal, "RuleDeleted", "Deleted rule "+rule.String())
 	}
-
-	// Get target nodes based on Agent nodeSelector
-	targetNodes, err := getTargetNodes(ctx, c)
-	if err != nil {
-		return fmt.Errorf("get target nodes: %w", err)
-	}
-
-	allAck := true
-	for _, node := range targetNodes {
-		k := annotationCleanupPrefix + node.Name
-		if fresh.Annotations == nil || fresh.Annotations[k] != ackValueDone {
-			allAck = false
-			break
-		}
-	}
-	if !allAck {
-		return nil
-	}
-	if err := deleteIPRuleConfigWithRetry(ctx, c, fresh); err != nil {
-		return fmt.Errorf("final delete: %w", err)
-	}
-	log.Printf("deleted IPRuleConfig %s after all node acks", fresh.Name)
-	return nil
+	r.setNodeStatus(ctx, cfg, apiv1alpha1.NodeStateRemoved, "")
 }
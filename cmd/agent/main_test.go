@@ -0,0 +1,166 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// kernelRule builds a rule the way the kernel reports it in a rule dump
+func kernelRule(src string, table, priority int) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Src, _ = ipToNet(src)
+	rule.Table = table
+	rule.Priority = priority
+	rule.Type = nl.FR_ACT_TO_TBL
+	return rule
+}
+
+func TestRuleKey(t *testing.T) {
+	fullMask := uint32(math.MaxUint32)
+	withMark := func(rule *netlink.Rule, mask *uint32) *netlink.Rule {
+		rule.Mark = 0x10
+		rule.Mask = mask
+		return rule
+	}
+	blackhole := kernelRule("10.0.0.5", 0, 1000)
+	blackhole.Type = nl.FR_ACT_BLACKHOLE
+
+	tests := []struct {
+		name string
+		rule *netlink.Rule
+		want string
+	}{
+		{"ipv4", kernelRule("10.0.0.5", 100, 1000),
+			"10.0.0.5|100|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"ipv6", kernelRule("fd00::10", 100, 1000),
+			"fd00::10|100|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"ipv6 spelled out", kernelRule("FD00:0:0:0:0:0:0:0010", 100, 1000),
+			"fd00::10|100|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"ipv4-mapped ipv6", kernelRule("::ffff:10.0.0.5", 100, 1000),
+			"10.0.0.5|100|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"vrf table", kernelRule("10.0.0.5", 1001, 1000),
+			"10.0.0.5|1001|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"priority zero", kernelRule("10.0.0.5", 100, 0),
+			"10.0.0.5|100|0|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"no source", &netlink.Rule{Table: 100, Priority: 1000, Type: nl.FR_ACT_TO_TBL, Goto: -1, SuppressPrefixlen: -1},
+			"|100|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"mark without mask", withMark(kernelRule("10.0.0.5", 100, 1000), nil),
+			"10.0.0.5|100|1000|to=|fwmark=0x10/0xffffffff|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"mark with full mask", withMark(kernelRule("10.0.0.5", 100, 1000), &fullMask),
+			"10.0.0.5|100|1000|to=|fwmark=0x10/0xffffffff|iif=|oif=|tos=0|ipproto=0|action=1"},
+		{"blackhole", blackhole,
+			"10.0.0.5|0|1000|to=|fwmark=0x0/0x0|iif=|oif=|tos=0|ipproto=0|action=6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleKey(tt.rule); got != tt.want {
+				t.Errorf("ruleKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDesiredRule(t *testing.T) {
+	suppress := int32(0)
+	tests := []struct {
+		name       string
+		spec       apiv1alpha1.IPRuleConfigSpec
+		wantErr    bool
+		wantFamily int
+		wantSrc    string
+		wantTable  int
+		wantType   uint8
+	}{
+		{name: "ipv4", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000},
+			wantFamily: netlink.FAMILY_V4, wantSrc: "10.0.0.5/32", wantTable: 100, wantType: nl.FR_ACT_TO_TBL},
+		{name: "ipv6", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "fd00::10", Table: 100, Priority: 1000},
+			wantFamily: netlink.FAMILY_V6, wantSrc: "fd00::10/128", wantTable: 100, wantType: nl.FR_ACT_TO_TBL},
+		{name: "ipv4-mapped ipv6", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "::ffff:10.0.0.5", Table: 100, Priority: 1000},
+			wantFamily: netlink.FAMILY_V4, wantSrc: "10.0.0.5/32", wantTable: 100, wantType: nl.FR_ACT_TO_TBL},
+		{name: "blackhole has no table", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000, RuleAction: apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionBlackhole}},
+			wantFamily: netlink.FAMILY_V4, wantSrc: "10.0.0.5/32", wantTable: 0, wantType: nl.FR_ACT_BLACKHOLE},
+		{name: "priority zero", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100}, wantErr: true},
+		{name: "neither table nor vrf", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Priority: 1000}, wantErr: true},
+		{name: "missing service ip", spec: apiv1alpha1.IPRuleConfigSpec{Table: 100, Priority: 1000}, wantErr: true},
+		{name: "malformed ip", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.256", Table: 100, Priority: 1000}, wantErr: true},
+		{name: "cidr instead of ip", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5/32", Table: 100, Priority: 1000}, wantErr: true},
+		{name: "unknown vrf", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", VRF: "vrf-does-not-exist", Priority: 1000}, wantErr: true},
+		{name: "vrf with blackhole", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", VRF: "vrf-red", Priority: 1000, RuleAction: apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionBlackhole}}, wantErr: true},
+		{name: "suppress with large table", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 1000, Priority: 1000, RuleAction: apiv1alpha1.RuleAction{SuppressPrefixLength: &suppress}}, wantErr: true},
+		{name: "goto backwards", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000, RuleAction: apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionGoto, GotoPriority: 900}}, wantErr: true},
+		{name: "dst of other family", spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000,
+			RuleSelector: apiv1alpha1.RuleSelector{Dst: "fd00::/64"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := desiredRule(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("desiredRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if rule.Family != tt.wantFamily {
+				t.Errorf("Family = %d, want %d", rule.Family, tt.wantFamily)
+			}
+			if rule.Src.String() != tt.wantSrc {
+				t.Errorf("Src = %s, want %s", rule.Src, tt.wantSrc)
+			}
+			if rule.Table != tt.wantTable || rule.Type != tt.wantType {
+				t.Errorf("Table/Type = %d/%d, want %d/%d", rule.Table, rule.Type, tt.wantTable, tt.wantType)
+			}
+			if rule.Priority != tt.spec.Priority || rule.Protocol != managedRuleProtocol {
+				t.Errorf("Priority/Protocol = %d/%d, want %d/%d", rule.Priority, rule.Protocol, tt.spec.Priority, managedRuleProtocol)
+			}
+		})
+	}
+}
+
+// TestDesiredRuleKeyMatchesKernel tests that a desired rule and the kernel's report of it share a
+// key, so the agent does not re-add rules that are in place
+func TestDesiredRuleKeyMatchesKernel(t *testing.T) {
+	fwMark := int64(0x10)
+	tests := []struct {
+		name   string
+		spec   apiv1alpha1.IPRuleConfigSpec
+		kernel func() *netlink.Rule
+	}{
+		{"ipv6 spelling", apiv1alpha1.IPRuleConfigSpec{ServiceIP: "FD00::0010", Table: 100, Priority: 1000},
+			func() *netlink.Rule { return kernelRule("fd00::10", 100, 1000) }},
+		{"fwmark with default mask", apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000,
+			RuleSelector: apiv1alpha1.RuleSelector{FwMark: &fwMark}},
+			func() *netlink.Rule {
+				rule := kernelRule("10.0.0.5", 100, 1000)
+				mask := uint32(math.MaxUint32)
+				rule.Mark, rule.Mask = 0x10, &mask
+				return rule
+			}},
+		{"dst", apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.5", Table: 100, Priority: 1000,
+			RuleSelector: apiv1alpha1.RuleSelector{Dst: "192.168.1.7/24"}},
+			func() *netlink.Rule {
+				rule := kernelRule("10.0.0.5", 100, 1000)
+				rule.Dst = &net.IPNet{IP: net.IPv4(192, 168, 1, 0).To4(), Mask: net.CIDRMask(24, 32)}
+				return rule
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := desiredRule(&tt.spec)
+			if err != nil {
+				t.Fatalf("desiredRule() error = %v", err)
+			}
+			if got, want := ruleKey(rule), ruleKey(tt.kernel()); got != want {
+				t.Errorf("ruleKey(desired) = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	metricManagedRules = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "iprule_agent_managed_rules",
+		Help: "Number of ip rules managed by the agent (proto 241) present on the node after the last sync",
+	})
+
+	metricRulesAdded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_agent_rules_added_total",
+		Help: "Total number of ip rules added for present IPRuleConfigs",
+	})
+
+	metricRulesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_agent_rules_deleted_total",
+		Help: "Total number of ip rules deleted for absent IPRuleConfigs",
+	})
+
+	metricRulesRepaired = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_agent_rules_repaired_total",
+		Help: "Total number of managed ip rules re-applied after they were removed out-of-band",
+	})
+
+	metricOrphanRulesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_agent_orphan_rules_deleted_total",
+		Help: "Total number of managed ip rules deleted because no present IPRuleConfig matched them",
+	})
+
+	metricNetlinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "iprule_agent_netlink_errors_total",
+		Help: "Total number of failed netlink calls by operation and errno",
+	}, []string{"operation", "errno"})
+
+	metricReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "iprule_agent_reconcile_duration_seconds",
+		Help:    "Duration of a full sync of rules and routes on the node",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	metricAuditPlanned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iprule_agent_audit_planned_changes",
+		Help: "Changes an agent in audit mode would make on the node, as of the last sync",
+	}, []string{"object", "action"})
+
+	metricLastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "iprule_agent_last_successful_sync_timestamp_seconds",
+		Help: "Unix time of the last sync that converged rules and routes without error",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		metricManagedRules,
+		metricRulesAdded,
+		metricRulesDeleted,
+		metricRulesRepaired,
+		metricOrphanRulesDeleted,
+		metricNetlinkErrors,
+		metricReconcileDuration,
+		metricAuditPlanned,
+		metricLastSuccessfulSync,
+	)
+}
+
+// countNetlinkError counts a failed netlink operation by the errno the kernel returned.
+func countNetlinkError(operation string, err error) {
+	errno := "unknown"
+	var e unix.Errno
+	if errors.As(err, &e) {
+		if errno = unix.ErrnoName(e); errno == "" {
+			errno = strconv.Itoa(int(e))
+		}
+	}
+	metricNetlinkErrors.WithLabelValues(operation, errno).Inc()
+}
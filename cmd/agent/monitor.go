@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// ruleMonitor subscribes to the kernel's rule and route notifications and triggers a reconcile
+// whenever a rule, or a route installed from a RoutingTable, is deleted on the host, e.g. by
+// `ip rule del` or a NetworkManager flush. The reconcile re-applies everything that went missing.
+type ruleMonitor struct {
+	events chan<- event.GenericEvent
+}
+
+// monitorReceiveTimeout bounds how long the monitor blocks in a receive before it checks for
+// shutdown.
+const monitorReceiveTimeout = time.Second
+
+// Start implements manager.Runnable. It returns once ctx is done.
+func (m *ruleMonitor) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("rule-monitor")
+	s, err := nl.Subscribe(unix.NETLINK_ROUTE, unix.RTNLGRP_IPV4_RULE, unix.RTNLGRP_IPV6_RULE,
+		unix.RTNLGRP_IPV4_ROUTE, unix.RTNLGRP_IPV6_ROUTE)
+	if err != nil {
+		return fmt.Errorf("subscribe to rule notifications: %w", err)
+	}
+	// Closing the socket wakes up a pending Receive; the receive timeout bounds the wait where it
+	// does not. done makes the closer exit when Start returns with an error as well.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		s.Close()
+	}()
+	timeout := unix.NsecToTimeval(monitorReceiveTimeout.Nanoseconds())
+	if err := s.SetReceiveTimeout(&timeout); err != nil {
+		return fmt.Errorf("set receive timeout: %w", err)
+	}
+	log.Info("watching kernel rule notifications")
+	for {
+		msgs, from, err := s.Receive()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) {
+				continue
+			}
+			// The socket buffer overflowed and notifications were lost; a full reconcile covers them.
+			if errors.Is(err, unix.ENOBUFS) {
+				log.Info("rule notifications dropped by the kernel, triggering reconcile")
+				m.trigger()
+				continue
+			}
+			return fmt.Errorf("receive rule notifications: %w", err)
+		}
+		if from.Pid != nl.PidKernel {
+			continue
+		}
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case unix.RTM_DELRULE:
+				log.V(1).Info("ip rule deleted on host, triggering reconcile")
+				m.trigger()
+			case unix.RTM_DELROUTE:
+				// Route churn of CNI plugins and others is ignored, only our own routes matter.
+				if len(msg.Data) >= unix.SizeofRtMsg && nl.DeserializeRtMsg(msg.Data).Protocol == uint8(managedRouteProtocol) {
+					log.V(1).Info("managed route deleted on host, triggering reconcile")
+					m.trigger()
+				}
+			}
+		}
+	}
+}
+
+// trigger enqueues a reconcile without blocking; a pending event already covers the new one.
+func (m *ruleMonitor) trigger() {
+	select {
+	case m.events <- event.GenericEvent{Object: &apiv1alpha1.IPRuleConfig{}}:
+	default:
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestRuleMonitorStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &ruleMonitor{events: make(chan event.GenericEvent, 1)}
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.Start(ctx) }()
+
+	// Give Start time to block in the receive
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(3 * monitorReceiveTimeout):
+		t.Fatal("Start() did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,236 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// managedRouteProtocol marks the routes the agent installs from RoutingTables ("proto 241" in
+// `ip route`), the same way managedRuleProtocol marks its rules.
+const managedRouteProtocol = netlink.RouteProtocol(managedRuleProtocol)
+
+// ipv6DefaultMetric is the metric the kernel assigns to IPv6 routes added without one.
+const ipv6DefaultMetric = 1024
+
+// reconcileRoutes converges the routes of all RoutingTables on this node and removes managed
+// routes that are no longer declared by any of them.
+func (r *ruleReconciler) reconcileRoutes(ctx context.Context) error {
+	log := logf.FromContext(ctx)
+	tables := &apiv1alpha1.RoutingTableList{}
+	if err := r.List(ctx, tables); err != nil {
+		return fmt.Errorf("list RoutingTables: %w", err)
+	}
+	nodeLabels, err := r.nodeLabels(ctx)
+	if err != nil {
+		return err
+	}
+	existing, err := netlink.RouteListFiltered(netlink.FAMILY_ALL,
+		&netlink.Route{Table: unix.RT_TABLE_UNSPEC, Protocol: managedRouteProtocol},
+		netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		countNetlinkError("route_list", err)
+		return fmt.Errorf("list routes: %w", err)
+	}
+	// Table names are best effort: a conflict or write error is reported, the routes still go in.
+	var nameErrs map[string]string
+	if r.IPRoute2Dir != "" && !r.Audit {
+		if nameErrs, err = syncTableNames(r.IPRoute2Dir, tables.Items); err != nil {
+			log.Error(err, "update rt_tables failed", "dir", r.IPRoute2Dir)
+			nameErrs = map[string]string{}
+			for i := range tables.Items {
+				nameErrs[tables.Items[i].Name] = fmt.Sprintf("register table name: %v", err)
+			}
+		}
+	}
+	installed := make(map[string]*netlink.Route, len(existing))
+	for i := range existing {
+		installed[routeKey(&existing[i])] = &existing[i]
+	}
+
+	desired := map[string]bool{}
+	plannedAdds, plannedDeletes := 0, 0
+	for i := range tables.Items {
+		rt := &tables.Items[i]
+		var errs []string
+		planned := false
+		if msg, ok := nameErrs[rt.Name]; ok {
+			errs = append(errs, msg)
+		}
+		for _, spec := range nodeRoutes(rt, nodeLabels) {
+			route, err := desiredRoute(rt.Spec.Table, spec)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", spec.Destination, err))
+				continue
+			}
+			key := routeKey(route)
+			desired[key] = true
+			if cur, ok := installed[key]; ok && routeMatches(cur, route) {
+				continue
+			}
+			if r.Audit {
+				plannedAdds++
+				planned = true
+				log.Info("audit: would install route", "routingTable", rt.Name, "route", route.String())
+				continue
+			}
+			// Replace also corrects a route whose gateway or device drifted.
+			if err := netlink.RouteReplace(route); err != nil {
+				countNetlinkError("route_replace", err)
+				errs = append(errs, fmt.Sprintf("%s: %v", spec.Destination, err))
+				continue
+			}
+			log.Info("installed route", "routingTable", rt.Name, "route", route.String())
+		}
+		if len(errs) > 0 {
+			r.setTableStatus(ctx, rt, apiv1alpha1.NodeStateFailed, strings.Join(errs, "; "))
+		} else if planned {
+			r.setTableStatus(ctx, rt, apiv1alpha1.NodeStateWouldAdd, "")
+		} else {
+			r.setTableStatus(ctx, rt, apiv1alpha1.NodeStateApplied, "")
+		}
+	}
+
+	for key, route := range installed {
+		if desired[key] {
+			continue
+		}
+		if r.Audit {
+			plannedDeletes++
+			log.Info("audit: would delete orphaned route", "route", route.String())
+			continue
+		}
+		if err := netlink.RouteDel(route); err != nil && !errors.Is(err, unix.ESRCH) {
+			countNetlinkError("route_del", err)
+			log.Error(err, "delete orphaned route failed", "route", route.String())
+			continue
+		}
+		log.Info("deleted orphaned route", "route", route.String())
+	}
+	if r.Audit {
+		metricAuditPlanned.WithLabelValues("route", "add").Set(float64(plannedAdds))
+		metricAuditPlanned.WithLabelValues("route", "delete").Set(float64(plannedDeletes))
+	}
+	return nil
+}
+
+// nodeRoutes returns the routes of rt for a node with the given labels. Every matching override
+// replaces the routes to the destinations it lists, in order.
+func nodeRoutes(rt *apiv1alpha1.RoutingTable, nodeLabels map[string]string) []apiv1alpha1.Route {
+	routes := rt.Spec.Routes
+	for _, o := range rt.Spec.NodeOverrides {
+		sel, err := metav1.LabelSelectorAsSelector(&o.NodeSelector)
+		if err != nil || !sel.Matches(labels.Set(nodeLabels)) {
+			continue
+		}
+		replaced := map[string]bool{}
+		for _, route := range o.Routes {
+			replaced[canonicalPrefix(route.Destination)] = true
+		}
+		merged := make([]apiv1alpha1.Route, 0, len(routes)+len(o.Routes))
+		for _, route := range routes {
+			if !replaced[canonicalPrefix(route.Destination)] {
+				merged = append(merged, route)
+			}
+		}
+		routes = append(merged, o.Routes...)
+	}
+	return routes
+}
+
+// canonicalPrefix normalises a prefix so "10.0.0.1/8" and "10.0.0.0/8" compare equal.
+func canonicalPrefix(s string) string {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return s
+	}
+	return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked().String()
+}
+
+// desiredRoute translates a Route of a RoutingTable into the netlink route the agent maintains.
+func desiredRoute(table int, spec apiv1alpha1.Route) (*netlink.Route, error) {
+	dst, err := netip.ParsePrefix(canonicalPrefix(spec.Destination))
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination: %w", err)
+	}
+	route := &netlink.Route{
+		Dst:      &net.IPNet{IP: dst.Addr().AsSlice(), Mask: net.CIDRMask(dst.Bits(), dst.Addr().BitLen())},
+		Table:    table,
+		Protocol: managedRouteProtocol,
+		Priority: int(spec.Metric),
+		Family:   netlink.FAMILY_V4,
+	}
+	if !dst.Addr().Is4() {
+		route.Family = netlink.FAMILY_V6
+		if route.Priority == 0 {
+			route.Priority = ipv6DefaultMetric
+		}
+	}
+	if spec.Device != "" {
+		link, err := netlink.LinkByName(spec.Device)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", spec.Device, err)
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+	if spec.Gateway == "" {
+		if route.LinkIndex == 0 {
+			return nil, errors.New("device must be set for routes without gateway")
+		}
+		route.Scope = netlink.SCOPE_LINK
+		return route, nil
+	}
+	gw, err := netip.ParseAddr(spec.Gateway)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway: %w", err)
+	}
+	gw = gw.Unmap()
+	if gw.Is4() != dst.Addr().Is4() {
+		return nil, errors.New("gateway and destination are of different IP families")
+	}
+	route.Gw = gw.AsSlice()
+	if spec.OnLink {
+		route.Flags = int(netlink.FLAG_ONLINK)
+	}
+	return route, nil
+}
+
+// routeKey identifies a route the way the kernel does within its table: by destination and
+// metric. Gateway and device are attributes of the route, compared by routeMatches.
+func routeKey(route *netlink.Route) string {
+	dst := ""
+	if route.Dst != nil {
+		dst = canonicalPrefix(route.Dst.String())
+	} else if route.Family == netlink.FAMILY_V6 {
+		dst = "::/0"
+	} else {
+		dst = "0.0.0.0/0"
+	}
+	return fmt.Sprintf("%d|%s|%d", route.Table, dst, route.Priority)
+}
+
+// routeMatches reports whether the installed route cur carries the attributes of want. The
+// device is only compared when want names one, as the kernel resolves it for gateway routes.
+func routeMatches(cur, want *netlink.Route) bool {
+	if !cur.Gw.Equal(want.Gw) {
+		return false
+	}
+	if want.LinkIndex != 0 && cur.LinkIndex != want.LinkIndex {
+		return false
+	}
+	return cur.Flags&int(netlink.FLAG_ONLINK) == want.Flags&int(netlink.FLAG_ONLINK)
+}
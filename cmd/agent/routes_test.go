@@ -0,0 +1,218 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+func TestNodeRoutes(t *testing.T) {
+	defaultVia := func(gw string) apiv1alpha1.Route {
+		return apiv1alpha1.Route{Destination: "0.0.0.0/0", Gateway: gw}
+	}
+	override := func(zone string, routes ...apiv1alpha1.Route) apiv1alpha1.RouteOverride {
+		return apiv1alpha1.RouteOverride{
+			NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"zone": zone}},
+			Routes:       routes,
+		}
+	}
+	rt := &apiv1alpha1.RoutingTable{Spec: apiv1alpha1.RoutingTableSpec{
+		Table: 100,
+		Routes: []apiv1alpha1.Route{
+			defaultVia("10.0.0.1"),
+			{Destination: "192.168.0.0/16", Gateway: "10.0.0.2"},
+		},
+		NodeOverrides: []apiv1alpha1.RouteOverride{
+			override("a", defaultVia("10.1.0.1")),
+			// Spelled differently, still replaces the 192.168.0.0/16 route
+			override("a", apiv1alpha1.Route{Destination: "192.168.1.1/16", Gateway: "10.1.0.2"}),
+			// A later override replaces the routes of an earlier one and adds new destinations
+			override("b", defaultVia("10.2.0.1"), apiv1alpha1.Route{Destination: "172.16.0.0/12", Gateway: "10.2.0.2"}),
+			override("b", defaultVia("10.2.0.9")),
+			// An invalid selector matches no node
+			{NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"zone": "not valid!"}}, Routes: []apiv1alpha1.Route{defaultVia("10.9.0.1")}},
+		},
+	}}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []apiv1alpha1.Route
+	}{
+		{"no override", map[string]string{"zone": "c"}, rt.Spec.Routes},
+		{"no labels", nil, rt.Spec.Routes},
+		{"overrides replace by destination", map[string]string{"zone": "a"}, []apiv1alpha1.Route{
+			defaultVia("10.1.0.1"),
+			{Destination: "192.168.1.1/16", Gateway: "10.1.0.2"},
+		}},
+		{"overrides apply in order", map[string]string{"zone": "b"}, []apiv1alpha1.Route{
+			{Destination: "192.168.0.0/16", Gateway: "10.0.0.2"},
+			{Destination: "172.16.0.0/12", Gateway: "10.2.0.2"},
+			defaultVia("10.2.0.9"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeRoutes(rt, tt.labels); !slices.Equal(got, tt.want) {
+				t.Errorf("nodeRoutes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(rt.Spec.Routes) != 2 || rt.Spec.Routes[0] != defaultVia("10.0.0.1") {
+		t.Errorf("nodeRoutes() modified the RoutingTable: %v", rt.Spec.Routes)
+	}
+}
+
+func TestCanonicalPrefix(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{"10.0.0.1/8", "10.0.0.0/8"},
+		{"0.0.0.0/0", "0.0.0.0/0"},
+		{"::ffff:10.0.0.1/8", "10.0.0.0/8"},
+		{"FD00::0010/64", "fd00::/64"},
+		{"::/0", "::/0"},
+		{"not-a-prefix", "not-a-prefix"},
+		{"10.0.0.1", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := canonicalPrefix(tt.in); got != tt.want {
+			t.Errorf("canonicalPrefix(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDesiredRoute(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         apiv1alpha1.Route
+		wantErr      bool
+		wantKey      string
+		wantGw       string
+		wantScope    netlink.Scope
+		wantOnLink   bool
+		wantLinkName string
+	}{
+		{name: "ipv4 via gateway", spec: apiv1alpha1.Route{Destination: "10.1.2.3/16", Gateway: "10.0.0.1"},
+			wantKey: "100|10.1.0.0/16|0", wantGw: "10.0.0.1"},
+		{name: "ipv4 metric", spec: apiv1alpha1.Route{Destination: "0.0.0.0/0", Gateway: "10.0.0.1", Metric: 50},
+			wantKey: "100|0.0.0.0/0|50", wantGw: "10.0.0.1"},
+		{name: "ipv6 default metric", spec: apiv1alpha1.Route{Destination: "::/0", Gateway: "fd00::1"},
+			wantKey: "100|::/0|1024", wantGw: "fd00::1"},
+		{name: "ipv6 metric", spec: apiv1alpha1.Route{Destination: "fd01::/64", Gateway: "fd00::1", Metric: 10},
+			wantKey: "100|fd01::/64|10", wantGw: "fd00::1"},
+		{name: "ipv4-mapped gateway", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16", Gateway: "::ffff:10.0.0.1"},
+			wantKey: "100|10.1.0.0/16|0", wantGw: "10.0.0.1"},
+		{name: "onlink", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16", Gateway: "10.0.0.1", Device: "lo", OnLink: true},
+			wantKey: "100|10.1.0.0/16|0", wantGw: "10.0.0.1", wantOnLink: true, wantLinkName: "lo"},
+		{name: "device only", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16", Device: "lo"},
+			wantKey: "100|10.1.0.0/16|0", wantScope: netlink.SCOPE_LINK, wantLinkName: "lo"},
+		{name: "neither gateway nor device", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16"}, wantErr: true},
+		{name: "unknown device", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16", Device: "does-not-exist0"}, wantErr: true},
+		{name: "invalid destination", spec: apiv1alpha1.Route{Destination: "10.1.0.0/33", Gateway: "10.0.0.1"}, wantErr: true},
+		{name: "invalid gateway", spec: apiv1alpha1.Route{Destination: "10.1.0.0/16", Gateway: "10.0.0.256"}, wantErr: true},
+		{name: "mixed families", spec: apiv1alpha1.Route{Destination: "fd01::/64", Gateway: "10.0.0.1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, err := desiredRoute(100, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("desiredRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := routeKey(route); got != tt.wantKey {
+				t.Errorf("routeKey() = %q, want %q", got, tt.wantKey)
+			}
+			if tt.wantGw == "" && route.Gw != nil || tt.wantGw != "" && !route.Gw.Equal(net.ParseIP(tt.wantGw)) {
+				t.Errorf("Gw = %v, want %s", route.Gw, tt.wantGw)
+			}
+			if route.Scope != tt.wantScope {
+				t.Errorf("Scope = %v, want %v", route.Scope, tt.wantScope)
+			}
+			if onLink := route.Flags&int(netlink.FLAG_ONLINK) != 0; onLink != tt.wantOnLink {
+				t.Errorf("onlink = %v, want %v", onLink, tt.wantOnLink)
+			}
+			if tt.wantLinkName != "" {
+				link, err := netlink.LinkByName(tt.wantLinkName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if route.LinkIndex != link.Attrs().Index {
+					t.Errorf("LinkIndex = %d, want %d", route.LinkIndex, link.Attrs().Index)
+				}
+			}
+			if route.Table != 100 || route.Protocol != managedRouteProtocol {
+				t.Errorf("Table/Protocol = %d/%d, want 100/%d", route.Table, route.Protocol, managedRouteProtocol)
+			}
+		})
+	}
+}
+
+func TestRouteKey(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.1.0.0/16")
+	tests := []struct {
+		name  string
+		route netlink.Route
+		want  string
+	}{
+		{"destination", netlink.Route{Table: 100, Dst: v4, Priority: 10}, "100|10.1.0.0/16|10"},
+		{"ipv4 default", netlink.Route{Table: 100, Family: netlink.FAMILY_V4}, "100|0.0.0.0/0|0"},
+		// The kernel reports default routes without Dst
+		{"ipv6 default", netlink.Route{Table: 100, Family: netlink.FAMILY_V6, Priority: ipv6DefaultMetric}, "100|::/0|1024"},
+	}
+	for _, tt := range tests {
+		if got := routeKey(&tt.route); got != tt.want {
+			t.Errorf("%s: routeKey() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// A desired default route and the kernel's report of it share a key
+	for _, spec := range []apiv1alpha1.Route{
+		{Destination: "0.0.0.0/0", Gateway: "10.0.0.1"},
+		{Destination: "::/0", Gateway: "fd00::1"},
+	} {
+		want, err := desiredRoute(100, spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kernel := &netlink.Route{Table: 100, Family: want.Family, Priority: want.Priority, Gw: want.Gw}
+		if routeKey(kernel) != routeKey(want) {
+			t.Errorf("routeKey(kernel) = %q, want %q", routeKey(kernel), routeKey(want))
+		}
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	gw := net.ParseIP("10.0.0.1").To4()
+	tests := []struct {
+		name      string
+		cur, want netlink.Route
+		match     bool
+	}{
+		{"same gateway", netlink.Route{Gw: gw, LinkIndex: 2}, netlink.Route{Gw: gw}, true},
+		{"other gateway", netlink.Route{Gw: net.ParseIP("10.0.0.2")}, netlink.Route{Gw: gw}, false},
+		{"ipv4 gateway in 16 byte form", netlink.Route{Gw: net.ParseIP("10.0.0.1")}, netlink.Route{Gw: gw}, true},
+		{"device resolved by the kernel", netlink.Route{Gw: gw, LinkIndex: 3}, netlink.Route{Gw: gw, LinkIndex: 0}, true},
+		{"other device", netlink.Route{Gw: gw, LinkIndex: 3}, netlink.Route{Gw: gw, LinkIndex: 2}, false},
+		{"onlink missing", netlink.Route{Gw: gw}, netlink.Route{Gw: gw, Flags: int(netlink.FLAG_ONLINK)}, false},
+		{"onlink set", netlink.Route{Gw: gw, Flags: int(netlink.FLAG_ONLINK)}, netlink.Route{Gw: gw, Flags: int(netlink.FLAG_ONLINK)}, true},
+		{"device route", netlink.Route{LinkIndex: 2}, netlink.Route{LinkIndex: 2}, true},
+		{"gateway added", netlink.Route{Gw: gw, LinkIndex: 2}, netlink.Route{LinkIndex: 2}, false},
+	}
+	for _, tt := range tests {
+		if got := routeMatches(&tt.cur, &tt.want); got != tt.match {
+			t.Errorf("%s: routeMatches() = %v, want %v", tt.name, got, tt.match)
+		}
+	}
+}
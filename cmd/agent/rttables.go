@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// rtTablesFile is the file below the iproute2 configuration directory the agent registers the
+// RoutingTable names in. iproute2 reads every *.conf in rt_tables.d next to rt_tables.
+const rtTablesFile = "rt_tables.d/ip-rule-operator.conf"
+
+// builtinTables are resolved by iproute2 without any rt_tables entry.
+var builtinTables = map[uint32]string{
+	0:   "unspec",
+	253: "default",
+	254: "main",
+	255: "local",
+}
+
+// syncTableNames registers "<table> <name>" for every RoutingTable in the agent's rt_tables.d
+// file below dir, so `ip rule` and `ip route` on the node show the names. A RoutingTable whose
+// name or table the host already maps differently is left out; the conflict is returned keyed by
+// the RoutingTable name. The file is only rewritten when its content changes.
+func syncTableNames(dir string, tables []apiv1alpha1.RoutingTable) (map[string]string, error) {
+	ids, names, err := hostTableNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := map[string]string{}
+	var lines []string
+	for i := range tables {
+		name, id := tables[i].Name, uint32(tables[i].Spec.Table)
+		if other, ok := ids[id]; ok && other != name {
+			conflicts[name] = fmt.Sprintf("table %d is already named %q on the host", id, other)
+			continue
+		}
+		if other, ok := names[name]; ok && other != id {
+			conflicts[name] = fmt.Sprintf("name %q already refers to table %d on the host", name, other)
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d\t%s", id, name))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(dir, rtTablesFile)
+	if len(lines) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return conflicts, err
+		}
+		return conflicts, nil
+	}
+	content := []byte("# Managed by the iprule-agent from the RoutingTable resources; do not edit.\n" +
+		strings.Join(lines, "\n") + "\n")
+	if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, content) {
+		return conflicts, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return conflicts, err
+	}
+	// Write and rename, so iproute2 never reads a half-written file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return conflicts, err
+	}
+	return conflicts, os.Rename(tmp, path)
+}
+
+// hostTableNames collects the table names the host defines besides the agent's own file: the
+// built-in ones, rt_tables and the other files in rt_tables.d.
+func hostTableNames(dir string) (ids map[uint32]string, names map[string]uint32, err error) {
+	ids = map[uint32]string{}
+	names = map[string]uint32{}
+	for id, name := range builtinTables {
+		ids[id] = name
+		names[name] = id
+	}
+	files := []string{filepath.Join(dir, "rt_tables")}
+	confs, err := filepath.Glob(filepath.Join(dir, "rt_tables.d", "*.conf"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range confs {
+		if f != filepath.Join(dir, rtTablesFile) {
+			files = append(files, f)
+		}
+	}
+	for _, f := range files {
+		if err := parseRTTables(f, ids, names); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, names, nil
+}
+
+// parseRTTables adds the entries of an rt_tables file to ids and names. Lines iproute2 would
+// ignore are skipped; a missing file is not an error.
+func parseRTTables(path string, ids map[uint32]string, names map[string]uint32) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[0], 0, 32)
+		if err != nil {
+			continue
+		}
+		ids[uint32(id)] = fields[1]
+		names[fields[1]] = uint32(id)
+	}
+	return s.Err()
+}
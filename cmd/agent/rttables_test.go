@@ -0,0 +1,168 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+func routingTable(name string, table int) apiv1alpha1.RoutingTable {
+	return apiv1alpha1.RoutingTable{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       apiv1alpha1.RoutingTableSpec{Table: table},
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+const rtTablesHeader = "# Managed by the iprule-agent from the RoutingTable resources; do not edit.\n"
+
+func TestParseRTTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rt_tables")
+	writeFile(t, path, `#
+# reserved values
+#
+255	local
+254	main
+0x64 uplink
+200	 edge   # trailing comment
+# 300 commented
+bogus	name
+400
+`)
+	ids, names := map[uint32]string{}, map[string]uint32{}
+	if err := parseRTTables(path, ids, names); err != nil {
+		t.Fatalf("parseRTTables() error = %v", err)
+	}
+	wantIDs := map[uint32]string{255: "local", 254: "main", 100: "uplink", 200: "edge"}
+	if len(ids) != len(wantIDs) {
+		t.Errorf("Expected %d tables, got %v", len(wantIDs), ids)
+	}
+	for id, name := range wantIDs {
+		if ids[id] != name || names[name] != id {
+			t.Errorf("Expected table %d named %q, got ids=%v names=%v", id, name, ids, names)
+		}
+	}
+
+	if err := parseRTTables(filepath.Join(t.TempDir(), "missing"), ids, names); err != nil {
+		t.Errorf("Expected a missing file to be ignored, got %v", err)
+	}
+}
+
+func TestSyncTableNames(t *testing.T) {
+	tests := []struct {
+		name          string
+		host          map[string]string // files below the iproute2 directory
+		tables        []apiv1alpha1.RoutingTable
+		wantFile      string // "" expects no agent file
+		wantConflicts map[string]string
+	}{
+		{
+			name:     "register names",
+			tables:   []apiv1alpha1.RoutingTable{routingTable("uplink", 100), routingTable("edge", 1000)},
+			wantFile: rtTablesHeader + "100\tuplink\n1000\tedge\n",
+		},
+		{
+			name:     "host entry with the same name and table",
+			host:     map[string]string{"rt_tables": "100 uplink\n"},
+			tables:   []apiv1alpha1.RoutingTable{routingTable("uplink", 100)},
+			wantFile: rtTablesHeader + "100\tuplink\n",
+		},
+		{
+			name:          "table already named on the host",
+			host:          map[string]string{"rt_tables": "100 isp\n"},
+			tables:        []apiv1alpha1.RoutingTable{routingTable("uplink", 100), routingTable("edge", 200)},
+			wantFile:      rtTablesHeader + "200\tedge\n",
+			wantConflicts: map[string]string{"uplink": `table 100 is already named "isp" on the host`},
+		},
+		{
+			name:          "name refers to another table in rt_tables.d",
+			host:          map[string]string{"rt_tables.d/vpn.conf": "300 uplink\n"},
+			tables:        []apiv1alpha1.RoutingTable{routingTable("uplink", 100)},
+			wantConflicts: map[string]string{"uplink": `name "uplink" already refers to table 300 on the host`},
+		},
+		{
+			name:          "builtin table",
+			tables:        []apiv1alpha1.RoutingTable{routingTable("main", 100), routingTable("uplink", 254)},
+			wantConflicts: map[string]string{"main": `name "main" already refers to table 254 on the host`, "uplink": `table 254 is already named "main" on the host`},
+		},
+		{
+			name:     "stale entries are removed",
+			host:     map[string]string{rtTablesFile: rtTablesHeader + "100\tuplink\n200\told\n"},
+			tables:   []apiv1alpha1.RoutingTable{routingTable("uplink", 100)},
+			wantFile: rtTablesHeader + "100\tuplink\n",
+		},
+		{
+			name: "own file does not conflict with itself",
+			host: map[string]string{rtTablesFile: rtTablesHeader + "100\tuplink\n"},
+			// uplink moved to another table
+			tables:   []apiv1alpha1.RoutingTable{routingTable("uplink", 101)},
+			wantFile: rtTablesHeader + "101\tuplink\n",
+		},
+		{
+			name: "no tables left",
+			host: map[string]string{rtTablesFile: rtTablesHeader + "100\tuplink\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for f, content := range tt.host {
+				writeFile(t, filepath.Join(dir, f), content)
+			}
+			conflicts, err := syncTableNames(dir, tt.tables)
+			if err != nil {
+				t.Fatalf("syncTableNames() error = %v", err)
+			}
+			if len(conflicts) != len(tt.wantConflicts) {
+				t.Errorf("conflicts = %v, want %v", conflicts, tt.wantConflicts)
+			}
+			for name, msg := range tt.wantConflicts {
+				if conflicts[name] != msg {
+					t.Errorf("conflict of %s = %q, want %q", name, conflicts[name], msg)
+				}
+			}
+			path := filepath.Join(dir, rtTablesFile)
+			if tt.wantFile == "" {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("Expected no %s, got %v", rtTablesFile, err)
+				}
+			} else if got := readFile(t, path); got != tt.wantFile {
+				t.Errorf("%s = %q, want %q", rtTablesFile, got, tt.wantFile)
+			}
+			// Hand-written files are never touched
+			for f, content := range tt.host {
+				if f == rtTablesFile {
+					continue
+				}
+				if got := readFile(t, filepath.Join(dir, f)); got != content {
+					t.Errorf("%s = %q, want it untouched (%q)", f, got, content)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// listRules dumps the rules of both families like netlink.RuleList, but also keeps the rule
+// action (fib_rule_hdr.action) in Rule.Type, which netlink.RuleList drops. Without it a blackhole
+// rule could not be told apart from a lookup rule with the same selectors. Only the attributes
+// the agent manages are decoded.
+func listRules() ([]netlink.Rule, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETRULE, unix.NLM_F_DUMP|unix.NLM_F_REQUEST)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	// An interrupted dump is incomplete; treating it as an error keeps the garbage collection
+	// from deleting rules it did not see.
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWRULE)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]netlink.Rule, 0, len(msgs))
+	for _, m := range msgs {
+		msg := nl.DeserializeRtMsg(m)
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, fmt.Errorf("parse rule attributes: %w", err)
+		}
+		rule := netlink.NewRule()
+		rule.Priority = 0 // FRA_PRIORITY is omitted for priority 0
+		rule.Family = int(msg.Family)
+		rule.Type = msg.Type
+		rule.Tos = uint(msg.Tos)
+		rule.Table = int(msg.Table)
+		rule.Invert = msg.Flags&netlink.FibRuleInvert != 0
+		for _, a := range attrs {
+			v := a.Value
+			switch a.Attr.Type {
+			case nl.FRA_TABLE:
+				rule.Table = int(native32(v))
+			case nl.FRA_PRIORITY:
+				rule.Priority = int(native32(v))
+			case nl.FRA_SRC:
+				rule.Src = &net.IPNet{IP: v, Mask: net.CIDRMask(int(msg.Src_len), 8*len(v))}
+			case nl.FRA_DST:
+				rule.Dst = &net.IPNet{IP: v, Mask: net.CIDRMask(int(msg.Dst_len), 8*len(v))}
+			case nl.FRA_FWMARK:
+				rule.Mark = native32(v)
+			case nl.FRA_FWMASK:
+				mask := native32(v)
+				rule.Mask = &mask
+			case nl.FRA_IIFNAME:
+				rule.IifName = string(v[:len(v)-1])
+			case nl.FRA_OIFNAME:
+				rule.OifName = string(v[:len(v)-1])
+			case nl.FRA_GOTO:
+				rule.Goto = int(native32(v))
+			case nl.FRA_SUPPRESS_PREFIXLEN:
+				if l := native32(v); l != 0xffffffff {
+					rule.SuppressPrefixlen = int(l)
+				}
+			case nl.FRA_IP_PROTO:
+				rule.IPProto = int(v[0])
+			case nl.FRA_SPORT_RANGE:
+				rule.Sport = netlink.NewRulePortRange(nl.NativeEndian().Uint16(v[0:2]), nl.NativeEndian().Uint16(v[2:4]))
+			case nl.FRA_DPORT_RANGE:
+				rule.Dport = netlink.NewRulePortRange(nl.NativeEndian().Uint16(v[0:2]), nl.NativeEndian().Uint16(v[2:4]))
+			case nl.FRA_UID_RANGE:
+				rule.UIDRange = netlink.NewRuleUIDRange(native32(v[0:4]), native32(v[4:8]))
+			case nl.FRA_PROTOCOL:
+				rule.Protocol = v[0]
+			}
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, nil
+}
+
+func native32(b []byte) uint32 { return nl.NativeEndian().Uint32(b[0:4]) }
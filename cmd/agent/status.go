@@ -0,0 +1,108 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// statusFieldOwnerPrefix + <nodeName> is the server-side apply field manager of the agent. Each
+// agent owns exactly its own entry in status.nodes (list map keyed by nodeName), so agents on
+// different nodes never conflict with each other.
+const statusFieldOwnerPrefix = "iprule-agent-"
+
+// reportNodeStatus publishes the outcome for this node into status.nodes of an IPRuleConfig or
+// RoutingTable. The patch is only sent when state, error or observed generation changed to keep
+// the API traffic per resync low.
+func (r *ruleReconciler) reportNodeStatus(
+	ctx context.Context,
+	obj client.Object,
+	kind string,
+	nodes []apiv1alpha1.NodeRuleStatus,
+	state, lastError string,
+) error {
+	if r.NodeName == "" || r.statusCurrent(obj, nodes, state, lastError) {
+		return nil
+	}
+	entry := apiv1alpha1.NodeRuleStatus{
+		NodeName:           r.NodeName,
+		State:              state,
+		LastError:          lastError,
+		ObservedGeneration: obj.GetGeneration(),
+		LastUpdateTime:     metav1.Now(),
+	}
+	// Apply configuration as plain JSON: only the fields listed here are owned by this agent.
+	patch := map[string]any{
+		"apiVersion": apiv1alpha1.GroupVersion.String(),
+		"kind":       kind,
+		"metadata":   map[string]any{"name": obj.GetName()},
+		"status":     map[string]any{"nodes": []apiv1alpha1.NodeRuleStatus{entry}},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("marshal status patch: %w", err)
+	}
+	target, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("unexpected object type %T", obj)
+	}
+	if err := r.Status().Patch(ctx, target, client.RawPatch(types.ApplyPatchType, data),
+		client.FieldOwner(statusFieldOwnerPrefix+r.NodeName), client.ForceOwnership); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return nil
+}
+
+// statusCurrent reports whether this node's entry in nodes already holds state and lastError for
+// the current generation of obj.
+func (r *ruleReconciler) statusCurrent(obj client.Object, nodes []apiv1alpha1.NodeRuleStatus, state, lastError string) bool {
+	for _, n := range nodes {
+		if n.NodeName == r.NodeName {
+			return n.State == state && n.LastError == lastError && n.ObservedGeneration == obj.GetGeneration()
+		}
+	}
+	return false
+}
+
+// setNodeStatus reports the node state of an IPRuleConfig and only logs failures: a status write
+// must never keep the agent from converging the remaining rules. A new failure is also recorded
+// as an event; a persisting one is not repeated on every resync.
+func (r *ruleReconciler) setNodeStatus(ctx context.Context, cfg *apiv1alpha1.IPRuleConfig, state, lastError string) {
+	if state == apiv1alpha1.NodeStateFailed && !r.statusCurrent(cfg, cfg.Status.Nodes, state, lastError) {
+		r.ruleEvent(cfg, corev1.EventTypeWarning, "RuleFailed", "Rule failed: "+lastError)
+	}
+	if err := r.reportNodeStatus(ctx, cfg, "IPRuleConfig", cfg.Status.Nodes, state, lastError); err != nil {
+		logf.FromContext(ctx).Error(err, "report node status failed", "config", cfg.Name, "state", state)
+	}
+}
+
+// setTableStatus reports the node state of a RoutingTable, see setNodeStatus.
+func (r *ruleReconciler) setTableStatus(ctx context.Context, rt *apiv1alpha1.RoutingTable, state, lastError string) {
+	if err := r.reportNodeStatus(ctx, rt, "RoutingTable", rt.Status.Nodes, state, lastError); err != nil {
+		logf.FromContext(ctx).Error(err, "report node status failed", "routingTable", rt.Name, "state", state)
+	}
+}
+
+// ruleEvent records an event on the IPRuleConfig and on this node, so both `kubectl describe
+// ipruleconfig` and `kubectl describe node` tell what the agent did.
+func (r *ruleReconciler) ruleEvent(cfg *apiv1alpha1.IPRuleConfig, eventtype, reason, message string) {
+	if r.NodeName == "" {
+		r.Recorder.Event(cfg, eventtype, reason, message)
+		return
+	}
+	r.Recorder.Eventf(cfg, eventtype, reason, "%s on node %s", message, r.NodeName)
+	// The kubelet references its Node by name as UID as well, kubectl describe node finds both.
+	node := &corev1.ObjectReference{Kind: "Node", Name: r.NodeName, UID: types.UID(r.NodeName)}
+	r.Recorder.Eventf(node, eventtype, reason, "%s for IPRuleConfig %s", message, cfg.Name)
+}
@@ -21,16 +21,21 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -39,6 +44,7 @@ import (
 
 	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
 	"github.com/mariusbertram/ip-rule-operator/internal/controller"
+	webhookv1alpha1 "github.com/mariusbertram/ip-rule-operator/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -63,6 +69,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var defaultTable, defaultPriority int
+	var absentCleanupTimeout time.Duration
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -81,6 +89,13 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.IntVar(&defaultTable, "default-table", 100,
+		"The routing table written into IPRules that do not set spec.table.")
+	flag.IntVar(&defaultPriority, "default-priority", 1000,
+		"The rule priority written into IPRules that do not set spec.priority.")
+	flag.DurationVar(&absentCleanupTimeout, "absent-cleanup-timeout", 10*time.Minute,
+		"How long an absent IPRuleConfig waits for all ready agents to remove its rule before it is "+
+			"deleted anyway. 0 waits forever.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -89,6 +104,11 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := webhookv1alpha1.ValidateRuleDefaults(defaultTable, defaultPriority); err != nil {
+		setupLog.Error(err, "invalid IPRule defaults")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -185,6 +205,10 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "fed43742.brtrm.dev",
+		// Only the agent pods are read (absent-config cleanup); do not cache every pod of the cluster.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{"app": "iprule-agent"})},
+		}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -203,19 +227,56 @@ func main() {
 	}
 
 	if err := (&controller.IPRuleReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		Recorder:        mgr.GetEventRecorderFor("iprule-controller"),
+		DefaultTable:    defaultTable,
+		DefaultPriority: defaultPriority,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "IpRule")
 		os.Exit(1)
 	}
-	if err := (&controller.AgentReconciler{
+	if err := (&controller.IPRuleConfigReconciler{
+		Client:         mgr.GetClient(),
+		Recorder:       mgr.GetEventRecorderFor("ipruleconfig-controller"),
+		CleanupTimeout: absentCleanupTimeout,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IPRuleConfig")
+		os.Exit(1)
+	}
+	if err := (&controller.RoutingTableReconciler{
 		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RoutingTable")
+		os.Exit(1)
+	}
+	if err := (&controller.AgentReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("agent-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Agent")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err := webhookv1alpha1.SetupIPRuleWebhookWithManager(mgr, defaultTable, defaultPriority); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "IPRule")
+			os.Exit(1)
+		}
+		if err := webhookv1alpha1.SetupIPRuleConfigWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "IPRuleConfig")
+			os.Exit(1)
+		}
+		if err := webhookv1alpha1.SetupAgentWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Agent")
+			os.Exit(1)
+		}
+		if err := webhookv1alpha1.SetupRoutingTableWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RoutingTable")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -20,6 +20,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"sort"
@@ -27,28 +28,113 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
 )
 
+// The agent pods run with hostNetwork, so these ports are taken on every node they run on.
+const (
+	agentMetricsPort = 9641
+	agentHealthPort  = 9642
+)
+
+// agentModeLabel on the agent pods carries the Agent's mode. The absent-config cleanup does not
+// wait for agents in audit mode, they never acknowledge.
+const agentModeLabel = "iprule.operator.brtrm.dev/agent-mode"
+
+// agentPoolLabel on the agent pods carries the name of their Agent, i.e. their pool. It is part of
+// the DaemonSet selector, so the DaemonSets of different pools never claim each other's pods.
+const agentPoolLabel = "iprule.operator.brtrm.dev/agent-pool"
+
+// daemonSetName returns the name of the DaemonSet (and PodMonitor) of a. The default pool keeps
+// the name it had before there were pools.
+func daemonSetName(a *apiv1alpha1.Agent) string {
+	if a.Name == apiv1alpha1.DefaultAgentPool {
+		return "iprule-agent"
+	}
+	return "iprule-agent-" + a.Name
+}
+
+// agentSelector returns the labels selecting the agent pods of a.
+func agentSelector(a *apiv1alpha1.Agent) map[string]string {
+	return map[string]string{"app": "iprule-agent", agentPoolLabel: a.Name}
+}
+
+// agentResources returns the resources of the agent container, by default small enough for the
+// handful of rules a node usually carries.
+func agentResources(a *apiv1alpha1.Agent) corev1.ResourceRequirements {
+	if a.Spec.Resources != nil {
+		return *a.Spec.Resources
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resourceMustParse("10m"), corev1.ResourceMemory: resourceMustParse("16Mi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resourceMustParse("100m"), corev1.ResourceMemory: resourceMustParse("64Mi")},
+	}
+}
+
+// agentPullPolicy returns the image pull policy of the agent container, defaulting to IfNotPresent.
+func agentPullPolicy(a *apiv1alpha1.Agent) corev1.PullPolicy {
+	if a.Spec.ImagePullPolicy == "" {
+		return corev1.PullIfNotPresent
+	}
+	return a.Spec.ImagePullPolicy
+}
+
+// agentReconcilePeriod returns RECONCILE_PERIOD of the agent, defaulting to 5m.
+func agentReconcilePeriod(a *apiv1alpha1.Agent) string {
+	if a.Spec.ReconcilePeriod == nil {
+		return "5m"
+	}
+	return a.Spec.ReconcilePeriod.Duration.String()
+}
+
+// agentArgs returns the command line of the agent; only the log level is configurable.
+func agentArgs(a *apiv1alpha1.Agent) []string {
+	if a.Spec.LogLevel == "" {
+		return nil
+	}
+	return []string{"--zap-log-level=" + a.Spec.LogLevel}
+}
+
+// agentMode returns the mode of a, defaulting to enforce.
+func agentMode(a *apiv1alpha1.Agent) apiv1alpha1.AgentMode {
+	if a.Spec.Mode == "" {
+		return apiv1alpha1.AgentModeEnforce
+	}
+	return a.Spec.Mode
+}
+
 // AgentReconciler reconciles Agent CRs and ensures a DaemonSet exists/updated
-// The DaemonSet name is fixed (iprule-agent) and lives in the same namespace as the Agent resource.
+// Every Agent is an agent pool with a DaemonSet of its own (see daemonSetName), in the same
+// namespace as the Agent resource.
 type AgentReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // RBAC: manage Agents and DaemonSets
-// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents,verbs=get;list;watch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
@@ -56,6 +142,9 @@ type AgentReconciler struct {
 // +kubebuilder:rbac:groups=apps,resources=daemonsets/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;create;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=ipruleconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=podmonitors,verbs=get;list;watch;create;update;patch;delete
 
 func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
@@ -69,15 +158,26 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Verify that the Agent resource is named "agent"
-	if agent.Name != "agent" {
-		logger.Error(fmt.Errorf("invalid agent name"), "Agent resource must be named 'agent'", "name", agent.Name)
+	if !agent.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, agent)
+	}
+	if !controllerutil.ContainsFinalizer(agent, cleanupFinalizer) {
+		controllerutil.AddFinalizer(agent, cleanupFinalizer)
+		if err := r.Update(ctx, agent); err != nil {
+			metricReconcileErrors.WithLabelValues("agent").Inc()
+			return ctrl.Result{}, err
+		}
+	}
+
+	affinity, err := agentAffinity(agent)
+	if err != nil {
+		logger.Error(err, "invalid node placement", "agent", agent.Name)
 		metricReconcileErrors.WithLabelValues("agent").Inc()
 		cond := metav1.Condition{
 			Type:               string(apiv1alpha1.AgentConditionReady),
 			Status:             metav1.ConditionFalse,
-			Reason:             "InvalidName",
-			Message:            "Agent resource must be named 'agent'",
+			Reason:             "InvalidNodeLabelSelector",
+			Message:            err.Error(),
 			ObservedGeneration: agent.Generation,
 			LastTransitionTime: metav1.Now(),
 		}
@@ -88,7 +188,7 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	}
 
 	// Desired DaemonSet name
-	name := "iprule-agent"
+	name := daemonSetName(agent)
 	image := agent.Spec.Image
 	if image == "" {
 		image = os.Getenv("RELATED_IMAGE_AGENT_IMAGE")
@@ -97,17 +197,26 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		image = "iprule-agent:latest"
 	}
 
-	// Hash over nodeSelector + tolerations + image
+	// Hash over image, mode and the pod-related spec fields
 	templateHash := computeTemplateHash(agent, image)
 
 	daemonSet := &appsv1.DaemonSet{}
 	key := types.NamespacedName{Name: name, Namespace: agent.Namespace}
+	// The deletion of the owned DaemonSet triggers the reconcile that recreates it
+	if replaced, err := r.replaceLegacyDaemonSet(ctx, agent, key); err != nil || replaced {
+		if err != nil {
+			metricReconcileErrors.WithLabelValues("agent").Inc()
+		}
+		return ctrl.Result{}, err
+	}
 	// ensure name/namespace set before CreateOrUpdate to avoid empty name error
 	daemonSet.Name = key.Name
 	daemonSet.Namespace = key.Namespace
+	var prevGeneration int64
 	result, err := ctrl.CreateOrUpdate(ctx, r.Client, daemonSet, func() error {
+		prevGeneration = daemonSet.Generation
 		if daemonSet.CreationTimestamp.IsZero() {
-			daemonSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "iprule-agent"}}
+			daemonSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: agentSelector(agent)}
 			// Set RollingUpdate strategy (maxUnavailable=1)
 			daemonSet.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType, RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: intstrPtr(intstr.FromInt(1))}}
 		}
@@ -122,7 +231,8 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		for k, v := range labels {
 			daemonSet.Labels[k] = v
 		}
-		podLabels := map[string]string{"app": "iprule-agent"}
+		podLabels := agentSelector(agent)
+		podLabels[agentModeLabel] = string(agentMode(agent))
 		var tolerations []corev1.Toleration
 		if len(agent.Spec.Tolerations) > 0 {
 			tolerations = agent.Spec.Tolerations
@@ -133,23 +243,56 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 			DNSPolicy:          corev1.DNSClusterFirstWithHostNet,
 			NodeSelector:       agent.Spec.NodeSelector,
 			Tolerations:        tolerations,
+			Affinity:           affinity,
+			PriorityClassName:  agent.Spec.PriorityClassName,
+			ImagePullSecrets:   agent.Spec.ImagePullSecrets,
 			Containers: []corev1.Container{{
 				Name:            "agent",
 				Image:           image,
-				ImagePullPolicy: corev1.PullIfNotPresent,
+				ImagePullPolicy: agentPullPolicy(agent),
+				Args:            agentArgs(agent),
 				SecurityContext: &corev1.SecurityContext{AllowPrivilegeEscalation: boolPtr(false), Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_ADMIN"}}, RunAsNonRoot: boolPtr(false), RunAsUser: int64Ptr(0)},
-				Env: []corev1.EnvVar{
+				Env: append([]corev1.EnvVar{
 					{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
-					{Name: "RECONCILE_PERIOD", Value: "10s"},
+					{Name: "RECONCILE_PERIOD", Value: agentReconcilePeriod(agent)},
+					{Name: "IPROUTE2_DIR", Value: "/host/etc/iproute2"},
+					{Name: "AGENT_MODE", Value: string(agentMode(agent))},
+					{Name: "AGENT_POOL", Value: agent.Name},
+					{Name: "METRICS_BIND_ADDRESS", Value: fmt.Sprintf(":%d", agentMetricsPort)},
+					{Name: "HEALTH_PROBE_BIND_ADDRESS", Value: fmt.Sprintf(":%d", agentHealthPort)},
+				}, agent.Spec.Env...),
+				Ports: []corev1.ContainerPort{
+					{Name: "metrics", ContainerPort: agentMetricsPort, Protocol: corev1.ProtocolTCP},
+					{Name: "health", ContainerPort: agentHealthPort, Protocol: corev1.ProtocolTCP},
 				},
-				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resourceMustParse("10m"), corev1.ResourceMemory: resourceMustParse("16Mi")}, Limits: corev1.ResourceList{corev1.ResourceCPU: resourceMustParse("100m"), corev1.ResourceMemory: resourceMustParse("64Mi")}},
+				LivenessProbe: &corev1.Probe{
+					ProbeHandler:        corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("health")}},
+					InitialDelaySeconds: 15,
+					PeriodSeconds:       20,
+				},
+				// Ready once the node was synced; the absent-config cleanup only waits for ready agents
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler:  corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/readyz", Port: intstr.FromString("health")}},
+					PeriodSeconds: 10,
+				},
+				// RoutingTable names are registered in the host's rt_tables.d
+				VolumeMounts: []corev1.VolumeMount{{Name: "iproute2", MountPath: "/host/etc/iproute2"}},
+				Resources:    agentResources(agent),
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "iproute2",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{
+					Path: "/etc/iproute2",
+					Type: hostPathTypePtr(corev1.HostPathDirectoryOrCreate),
+				}},
 			}},
 		}
-		daemonSet.Spec.Template = corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: podLabels}, Spec: podSpec}
-		if daemonSet.Spec.Template.Annotations == nil {
-			daemonSet.Spec.Template.Annotations = map[string]string{}
+		podAnnotations := map[string]string{}
+		for k, v := range agent.Spec.PodAnnotations {
+			podAnnotations[k] = v
 		}
-		daemonSet.Spec.Template.Annotations["iprule.operator.brtrm.dev/template-hash"] = templateHash
+		podAnnotations["iprule.operator.brtrm.dev/template-hash"] = templateHash
+		daemonSet.Spec.Template = corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: podLabels, Annotations: podAnnotations}, Spec: podSpec}
 		return controllerutil.SetControllerReference(agent, daemonSet, r.Scheme)
 	})
 	if err != nil {
@@ -162,24 +305,43 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	switch result {
 	case controllerutil.OperationResultCreated:
 		metricAgentDaemonSetOperations.WithLabelValues("created").Inc()
+		r.Recorder.Eventf(agent, corev1.EventTypeNormal, "DaemonSetCreated", "Created DaemonSet %s", name)
 	case controllerutil.OperationResultUpdated:
 		metricAgentDaemonSetOperations.WithLabelValues("updated").Inc()
+		// The template omits server-side defaults, so only a new generation is an actual rollout
+		if daemonSet.Generation != prevGeneration {
+			r.Recorder.Eventf(agent, corev1.EventTypeNormal, "DaemonSetUpdated", "Updated DaemonSet %s, rolling out template %s", name, templateHash)
+		}
 	case controllerutil.OperationResultNone:
 		metricAgentDaemonSetOperations.WithLabelValues("unchanged").Inc()
 	}
 
+	if err := r.reconcilePodMonitor(ctx, agent); err != nil {
+		// Monitoring is optional, the agents work without it
+		logger.Error(err, "reconcile podmonitor failed")
+		r.Recorder.Eventf(agent, corev1.EventTypeWarning, "PodMonitorFailed", "PodMonitor not reconciled: %v", err)
+	}
+
+	overlap, err := r.poolOverlap(ctx, agent)
+	if err != nil {
+		metricReconcileErrors.WithLabelValues("agent").Inc()
+		return ctrl.Result{}, err
+	}
+
 	// Update status based on DaemonSet status
+	prevStatus := agent.Status.DeepCopy()
 	agent.Status.ObservedGeneration = agent.Generation
 	if err := r.Get(ctx, key, daemonSet); err == nil {
 		st := daemonSet.Status
+		r.recordRollout(agent, prevStatus, st)
 		agent.Status.DesiredNumberScheduled = st.DesiredNumberScheduled
 		agent.Status.CurrentNumberScheduled = st.CurrentNumberScheduled
 		agent.Status.NumberReady = st.NumberReady
 
 		// Update metrics
-		metricAgentDaemonSetDesired.Set(float64(st.DesiredNumberScheduled))
-		metricAgentDaemonSetCurrent.Set(float64(st.CurrentNumberScheduled))
-		metricAgentDaemonSetReady.Set(float64(st.NumberReady))
+		metricAgentDaemonSetDesired.WithLabelValues(agent.Name).Set(float64(st.DesiredNumberScheduled))
+		metricAgentDaemonSetCurrent.WithLabelValues(agent.Name).Set(float64(st.CurrentNumberScheduled))
+		metricAgentDaemonSetReady.WithLabelValues(agent.Name).Set(float64(st.NumberReady))
 
 		readyCond := metav1.Condition{Type: string(apiv1alpha1.AgentConditionReady)}
 		if st.NumberReady > 0 && st.NumberReady == st.DesiredNumberScheduled {
@@ -191,6 +353,14 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 			readyCond.Reason = "Progressing"
 			readyCond.Message = fmt.Sprintf("Desired=%d Current=%d Ready=%d", st.DesiredNumberScheduled, st.CurrentNumberScheduled, st.NumberReady)
 		}
+		if overlap != "" {
+			readyCond.Status = metav1.ConditionFalse
+			readyCond.Reason = "OverlappingPools"
+			readyCond.Message = overlap + "; agent pools must select disjoint nodes"
+			if prev := meta.FindStatusCondition(prevStatus.Conditions, readyCond.Type); prev == nil || prev.Reason != readyCond.Reason {
+				r.Recorder.Event(agent, corev1.EventTypeWarning, readyCond.Reason, readyCond.Message)
+			}
+		}
 		readyCond.ObservedGeneration = agent.Generation
 		readyCond.LastTransitionTime = metav1.Now()
 		agent.Status.Conditions = upsertCondition(agent.Status.Conditions, readyCond)
@@ -205,15 +375,212 @@ func (r *AgentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 	return ctrl.Result{}, nil
 }
 
+// podMonitorGVK is the Prometheus Operator PodMonitor. It is handled as unstructured object to
+// not depend on the Prometheus Operator API module.
+var podMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}
+
+// reconcilePodMonitor creates the PodMonitor for the agent pods if spec.podMonitor is set and
+// removes it otherwise.
+func (r *AgentReconciler) reconcilePodMonitor(ctx context.Context, agent *apiv1alpha1.Agent) error {
+	pm := &unstructured.Unstructured{}
+	pm.SetGroupVersionKind(podMonitorGVK)
+	pm.SetName(daemonSetName(agent))
+	pm.SetNamespace(agent.Namespace)
+	if !agent.Spec.PodMonitor {
+		if err := r.Delete(ctx, pm); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+		return nil
+	}
+	_, err := ctrl.CreateOrUpdate(ctx, r.Client, pm, func() error {
+		pm.SetLabels(map[string]string{"app.kubernetes.io/name": "ip-rule-operator", "managed-by": "ip-rule-operator"})
+		spec := map[string]any{
+			"selector": map[string]any{"matchLabels": map[string]any{"app": "iprule-agent", agentPoolLabel: agent.Name}},
+			"podMetricsEndpoints": []any{
+				map[string]any{"port": "metrics", "path": "/metrics"},
+			},
+		}
+		if err := unstructured.SetNestedField(pm.Object, spec, "spec"); err != nil {
+			return err
+		}
+		return controllerutil.SetControllerReference(agent, pm, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("the PodMonitor CRD is not installed: %w", err)
+	}
+	return err
+}
+
+// poolOverlap lists the Agents and nodes of the cluster and describes an Agent overlapping with
+// agent, see overlappingAgent.
+func (r *AgentReconciler) poolOverlap(ctx context.Context, agent *apiv1alpha1.Agent) (string, error) {
+	agents := &apiv1alpha1.AgentList{}
+	if err := r.List(ctx, agents); err != nil {
+		return "", fmt.Errorf("list agents: %w", err)
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return "", fmt.Errorf("list nodes: %w", err)
+	}
+	// Name the same Agent on every reconcile
+	sort.Slice(agents.Items, func(i, j int) bool {
+		a, b := agents.Items[i], agents.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return overlappingAgent(agent, agents.Items, nodes.Items)
+}
+
+// recordRollout emits an event when the rollout of the DaemonSet progressed or completed since the
+// last reconcile, so `kubectl describe agent` shows its history.
+func (r *AgentReconciler) recordRollout(agent *apiv1alpha1.Agent, prev *apiv1alpha1.AgentStatus, st appsv1.DaemonSetStatus) {
+	if prev.NumberReady == st.NumberReady && prev.DesiredNumberScheduled == st.DesiredNumberScheduled &&
+		prev.CurrentNumberScheduled == st.CurrentNumberScheduled {
+		return
+	}
+	if st.NumberReady > 0 && st.NumberReady == st.DesiredNumberScheduled {
+		r.Recorder.Eventf(agent, corev1.EventTypeNormal, "RolloutComplete", "All %d agent pods ready", st.NumberReady)
+		return
+	}
+	r.Recorder.Eventf(agent, corev1.EventTypeNormal, "RolloutProgressing", "Desired=%d Current=%d Ready=%d",
+		st.DesiredNumberScheduled, st.CurrentNumberScheduled, st.NumberReady)
+}
+
+// finalize keeps the DaemonSet of a deleted Agent running until the agents removed every rule of
+// its pool: the IPRule controller marks the pool's IPRuleConfigs absent meanwhile, and the
+// IPRuleConfig controller deletes them once the nodes acknowledged. The DaemonSet is
+// garbage-collected with the Agent.
+func (r *AgentReconciler) finalize(ctx context.Context, agent *apiv1alpha1.Agent) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(agent, cleanupFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	cfgs := &apiv1alpha1.IPRuleConfigList{}
+	if err := r.List(ctx, cfgs); err != nil {
+		metricReconcileErrors.WithLabelValues("agent").Inc()
+		return ctrl.Result{}, err
+	}
+	pool := configPool(agent.Name)
+	remaining := 0
+	for i := range cfgs.Items {
+		// Configs created by hand are never applied by the agents and do not hold up the deletion
+		if cfgs.Items[i].Labels["managed-by"] == "ip-rule-operator" && cfgs.Items[i].Spec.AgentPool == pool {
+			remaining++
+		}
+	}
+	if remaining > 0 {
+		cond := metav1.Condition{
+			Type:               string(apiv1alpha1.AgentConditionReady),
+			Status:             metav1.ConditionFalse,
+			Reason:             "TearingDown",
+			Message:            fmt.Sprintf("Waiting for the removal of %d IPRuleConfigs from the nodes", remaining),
+			ObservedGeneration: agent.Generation,
+			LastTransitionTime: metav1.Now(),
+		}
+		agent.Status.Conditions = upsertCondition(agent.Status.Conditions, cond)
+		if err := r.Status().Update(ctx, agent); err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		log.FromContext(ctx).Info("waiting for rule teardown before deleting agent", "remaining", remaining)
+		return ctrl.Result{}, nil
+	}
+	controllerutil.RemoveFinalizer(agent, cleanupFinalizer)
+	if err := r.Update(ctx, agent); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	metricAgentDaemonSetDesired.DeleteLabelValues(agent.Name)
+	metricAgentDaemonSetCurrent.DeleteLabelValues(agent.Name)
+	metricAgentDaemonSetReady.DeleteLabelValues(agent.Name)
+	log.FromContext(ctx).Info("all rules removed from the nodes, releasing agent")
+	return ctrl.Result{}, nil
+}
+
+// replaceLegacyDaemonSet deletes the DaemonSet at key if its selector is not the one of the
+// pool, as left behind by operator versions without agent pools. The selector of a DaemonSet is
+// immutable, so it is recreated on the next reconcile; the rules stay on the nodes meanwhile.
+func (r *AgentReconciler) replaceLegacyDaemonSet(ctx context.Context, agent *apiv1alpha1.Agent, key types.NamespacedName) (bool, error) {
+	existing := &appsv1.DaemonSet{}
+	if err := r.Get(ctx, key, existing); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if existing.Spec.Selector != nil && equality.Semantic.DeepEqual(existing.Spec.Selector.MatchLabels, agentSelector(agent)) {
+		return false, nil
+	}
+	if err := r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	log.FromContext(ctx).Info("deleted DaemonSet with outdated selector, recreating it", "name", key)
+	r.Recorder.Eventf(agent, corev1.EventTypeNormal, "DaemonSetReplaced", "Deleted DaemonSet %s with an outdated selector to recreate it", key.Name)
+	return true, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *AgentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&apiv1alpha1.Agent{}).
 		Owns(&appsv1.DaemonSet{}).
+		// A deleted Agent waits for the last IPRuleConfig to go
+		Watches(
+			&apiv1alpha1.IPRuleConfig{},
+			handler.EnqueueRequestsFromMapFunc(r.deletingAgents),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return false },
+				UpdateFunc:  func(event.UpdateEvent) bool { return false },
+				DeleteFunc:  func(event.DeleteEvent) bool { return true },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			}),
+		).
+		// The placement of the other Agents and the node labels and taints decide whether pools overlap
+		Watches(&apiv1alpha1.Agent{}, handler.EnqueueRequestsFromMapFunc(r.allAgents),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.allAgents),
+			builder.WithPredicates(predicate.Funcs{
+				UpdateFunc: func(e event.UpdateEvent) bool {
+					oldNode, okOld := e.ObjectOld.(*corev1.Node)
+					newNode, okNew := e.ObjectNew.(*corev1.Node)
+					if !okOld || !okNew {
+						return false
+					}
+					return !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels) ||
+						!equality.Semantic.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints)
+				},
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			})).
 		Named("agent").
 		Complete(r)
 }
 
+// allAgents maps to every Agent.
+func (r *AgentReconciler) allAgents(ctx context.Context, _ client.Object) []reconcile.Request {
+	agents := &apiv1alpha1.AgentList{}
+	if err := r.List(ctx, agents); err != nil {
+		log.FromContext(ctx).Error(err, "failed listing Agents")
+		return nil
+	}
+	reqs := make([]reconcile.Request, 0, len(agents.Items))
+	for i := range agents.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&agents.Items[i])})
+	}
+	return reqs
+}
+
+// deletingAgents maps to the Agents that are being deleted.
+func (r *AgentReconciler) deletingAgents(ctx context.Context, _ client.Object) []reconcile.Request {
+	agents := &apiv1alpha1.AgentList{}
+	if err := r.List(ctx, agents); err != nil {
+		log.FromContext(ctx).Error(err, "failed listing Agents")
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range agents.Items {
+		if !agents.Items[i].DeletionTimestamp.IsZero() {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&agents.Items[i])})
+		}
+	}
+	return reqs
+}
+
 // helper functions
 func boolPtr(b bool) *bool { return &b }
 
@@ -226,7 +593,7 @@ func computeTemplateHash(a *apiv1alpha1.Agent, image string) string {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
-	data := "img=" + image + ";ns="
+	data := "img=" + image + ";mode=" + string(agentMode(a)) + ";ns="
 	for _, k := range keys {
 		data += k + "=" + a.Spec.NodeSelector[k] + ";"
 	}
@@ -244,6 +611,21 @@ func computeTemplateHash(a *apiv1alpha1.Agent, image string) string {
 			data += fmt.Sprintf("tol:%s:%s:%s:%s;", t.Key, string(t.Operator), string(t.Effect), t.Value)
 		}
 	}
+	// The remaining pod fields are hashed in their JSON form (map keys are sorted by encoding/json)
+	pod, _ := json.Marshal(struct {
+		Resources         *corev1.ResourceRequirements
+		PriorityClassName string
+		ImagePullPolicy   corev1.PullPolicy
+		ImagePullSecrets  []corev1.LocalObjectReference
+		Affinity          *corev1.Affinity
+		PodAnnotations    map[string]string
+		Env               []corev1.EnvVar
+		LogLevel          string
+		ReconcilePeriod   *metav1.Duration
+		NodeLabelSelector *metav1.LabelSelector `json:",omitempty"`
+	}{a.Spec.Resources, a.Spec.PriorityClassName, a.Spec.ImagePullPolicy, a.Spec.ImagePullSecrets, a.Spec.Affinity,
+		a.Spec.PodAnnotations, a.Spec.Env, a.Spec.LogLevel, a.Spec.ReconcilePeriod, a.Spec.NodeLabelSelector})
+	data += "pod=" + string(pod) + ";"
 	sum := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(sum[:8])
 }
@@ -252,6 +634,8 @@ func intstrPtr(v intstr.IntOrString) *intstr.IntOrString { return &v }
 
 func int64Ptr(v int64) *int64 { return &v }
 
+func hostPathTypePtr(v corev1.HostPathType) *corev1.HostPathType { return &v }
+
 func upsertCondition(list []metav1.Condition, c metav1.Condition) []metav1.Condition {
 	out := make([]metav1.Condition, 0, len(list)+1)
 	found := false
@@ -18,12 +18,17 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -67,7 +72,8 @@ var _ = Describe("Agent Controller", func() {
 			resource := &apiv1alpha1.Agent{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			if err == nil {
-				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+				dropFinalizers(ctx, resource)
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
 			}
 
 			By("Cleanup the DaemonSet if exists")
@@ -80,9 +86,11 @@ var _ = Describe("Agent Controller", func() {
 
 		It("should successfully reconcile and create DaemonSet", func() {
 			By("Reconciling the created resource")
+			recorder := record.NewFakeRecorder(100)
 			controllerReconciler := &AgentReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -104,13 +112,71 @@ var _ = Describe("Agent Controller", func() {
 			Expect(ds.Spec.Template.Spec.Containers[0].Image).To(Equal("iprule-agent:test"))
 			Expect(ds.Spec.Template.Spec.NodeSelector).To(HaveKeyWithValue("kubernetes.io/os", "linux"))
 			Expect(ds.Spec.Template.Spec.HostNetwork).To(BeTrue())
+			Expect(ds.Spec.Template.Spec.Containers[0].Ports).To(HaveLen(2))
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "AGENT_MODE", Value: "enforce"}))
+			Expect(ds.Spec.Template.Labels).To(HaveKeyWithValue(agentModeLabel, "enforce"))
+			Expect(ds.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "iprule-agent", agentPoolLabel: "agent"}))
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "AGENT_POOL", Value: "agent"}))
+			Expect(ds.Spec.Template.Spec.Containers[0].LivenessProbe.HTTPGet.Path).To(Equal("/healthz"))
+			Expect(ds.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet.Path).To(Equal("/readyz"))
+			Expect(recorder.Events).To(Receive(ContainSubstring("DaemonSetCreated")))
+		})
+
+		It("should apply the pod options of the Agent spec to the DaemonSet", func() {
+			By("Setting the pod options")
+			agent := &apiv1alpha1.Agent{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			defaultHash := computeTemplateHash(agent, agent.Spec.Image)
+			agent.Spec.Resources = &corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			}
+			agent.Spec.PriorityClassName = "system-node-critical"
+			agent.Spec.ImagePullPolicy = corev1.PullAlways
+			agent.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+			agent.Spec.PodAnnotations = map[string]string{"example.com/team": "network"}
+			agent.Spec.Env = []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}}
+			agent.Spec.LogLevel = "debug"
+			agent.Spec.ReconcilePeriod = &metav1.Duration{Duration: 30 * time.Second}
+			agent.Spec.NodeLabelSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "node-role.kubernetes.io/control-plane", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}}
+			Expect(k8sClient.Update(ctx, agent)).To(Succeed())
+			Expect(computeTemplateHash(agent, agent.Spec.Image)).NotTo(Equal(defaultHash))
+
+			controllerReconciler := &AgentReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Verifying the DaemonSet carries them")
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "iprule-agent", Namespace: "default"}, ds)).To(Succeed())
+			pod := ds.Spec.Template
+			container := pod.Spec.Containers[0]
+			Expect(pod.Spec.PriorityClassName).To(Equal("system-node-critical"))
+			Expect(pod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "registry"}}))
+			Expect(pod.Annotations).To(HaveKeyWithValue("example.com/team", "network"))
+			Expect(pod.Annotations).To(HaveKey("iprule.operator.brtrm.dev/template-hash"))
+			Expect(container.ImagePullPolicy).To(Equal(corev1.PullAlways))
+			Expect(container.Resources.Limits.Memory().String()).To(Equal("256Mi"))
+			Expect(container.Args).To(Equal([]string{"--zap-log-level=debug"}))
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "RECONCILE_PERIOD", Value: "30s"}))
+			Expect(container.Env).To(ContainElement(corev1.EnvVar{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}))
+			Expect(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(Equal(
+				[]corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: "node-role.kubernetes.io/control-plane", Operator: corev1.NodeSelectorOpDoesNotExist},
+				}}}))
 		})
 
 		It("should update Agent status with DaemonSet status", func() {
 			By("Reconciling the Agent")
 			controllerReconciler := &AgentReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -132,29 +198,185 @@ var _ = Describe("Agent Controller", func() {
 			readyCond := findCondition(agent.Status.Conditions, string(apiv1alpha1.AgentConditionReady))
 			Expect(readyCond).NotTo(BeNil())
 		})
+
+		It("should replace a DaemonSet with the selector of operators without agent pools", func() {
+			legacy := &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "iprule-agent", Namespace: "default"},
+				Spec: appsv1.DaemonSetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "iprule-agent"}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "iprule-agent"}},
+						Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "agent", Image: "iprule-agent:old"}}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, legacy)).To(Succeed())
+
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &AgentReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.Events).To(Receive(ContainSubstring("DaemonSetReplaced")))
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "iprule-agent", Namespace: "default"}, ds)).To(Succeed())
+			Expect(ds.UID).NotTo(Equal(legacy.UID))
+			Expect(ds.Spec.Selector.MatchLabels).To(HaveKeyWithValue(agentPoolLabel, "agent"))
+		})
+
+		It("should keep a deleted Agent until all IPRuleConfigs are gone", func() {
+			controllerReconciler := &AgentReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			agent := &apiv1alpha1.Agent{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			Expect(agent.Finalizers).To(ContainElement(cleanupFinalizer))
+
+			By("Deleting the Agent while an IPRuleConfig exists")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "iprc-10-0-0-60", Labels: map[string]string{"managed-by": "ip-rule-operator"}},
+				Spec: apiv1alpha1.IPRuleConfigSpec{
+					ServiceIP: "10.0.0.60",
+					Table:     100,
+					Priority:  1000,
+					State:     apiv1alpha1.StateAbsent,
+				},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+			// A config created by hand is never applied by the agents and must not block the deletion
+			handMade := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "iprc-10-0-0-62"},
+				Spec:       apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.62", Table: 100, Priority: 1000, State: apiv1alpha1.StatePresent},
+			}
+			Expect(k8sClient.Create(ctx, handMade)).To(Succeed())
+			defer func() { Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, handMade))).To(Succeed()) }()
+			Expect(k8sClient.Delete(ctx, agent)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			readyCond := findCondition(agent.Status.Conditions, string(apiv1alpha1.AgentConditionReady))
+			Expect(readyCond).NotTo(BeNil())
+			Expect(readyCond.Reason).To(Equal("TearingDown"))
+			Expect(readyCond.Message).To(ContainSubstring("removal of 1 IPRuleConfigs"))
+
+			By("Deleting the IPRuleConfig")
+			Expect(k8sClient.Delete(ctx, cfg)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, agent)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
 	})
 
-	Context("When Agent has invalid name", func() {
-		const invalidName = "invalid-agent-name"
+	Context("When an Agent defines another agent pool", func() {
+		const poolName = "edge"
 
 		ctx := context.Background()
+		typeNamespacedName := types.NamespacedName{Name: poolName, Namespace: "default"}
 
-		It("should reject Agent with invalid name via CRD validation", func() {
-			By("Attempting to create Agent with invalid name")
+		AfterEach(func() {
+			agent := &apiv1alpha1.Agent{}
+			if err := k8sClient.Get(ctx, typeNamespacedName, agent); err == nil {
+				dropFinalizers(ctx, agent)
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, agent))).To(Succeed())
+			}
+			ds := &appsv1.DaemonSet{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "iprule-agent-edge", Namespace: "default"}, ds); err == nil {
+				Expect(k8sClient.Delete(ctx, ds)).To(Succeed())
+			}
+		})
+
+		It("should run a DaemonSet of its own and only wait for the IPRuleConfigs of its pool", func() {
 			agent := &apiv1alpha1.Agent{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      invalidName,
-					Namespace: "default",
-				},
-				Spec: apiv1alpha1.AgentSpec{
-					Image: "iprule-agent:test",
-				},
+				ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: "default"},
+				Spec:       apiv1alpha1.AgentSpec{Image: "iprule-agent:test", NodeSelector: map[string]string{"pool": "edge"}},
+			}
+			Expect(k8sClient.Create(ctx, agent)).To(Succeed())
+
+			controllerReconciler := &AgentReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			ds := &appsv1.DaemonSet{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "iprule-agent-edge", Namespace: "default"}, ds)).To(Succeed())
+			Expect(ds.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "iprule-agent", agentPoolLabel: poolName}))
+			Expect(ds.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "AGENT_POOL", Value: poolName}))
+
+			By("Deleting the Agent while only an IPRuleConfig of the default pool exists")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "iprc-10-0-0-61", Labels: map[string]string{"managed-by": "ip-rule-operator"}},
+				Spec:       apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.61", Table: 100, Priority: 1000, State: apiv1alpha1.StatePresent},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+			defer func() { Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cfg))).To(Succeed()) }()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, agent)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, agent)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should wait for the IPRuleConfigs of its own pool", func() {
+			agent := &apiv1alpha1.Agent{
+				ObjectMeta: metav1.ObjectMeta{Name: poolName, Namespace: "default"},
+				Spec:       apiv1alpha1.AgentSpec{Image: "iprule-agent:test", NodeSelector: map[string]string{"pool": "edge"}},
+			}
+			Expect(k8sClient.Create(ctx, agent)).To(Succeed())
+
+			controllerReconciler := &AgentReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
-			err := k8sClient.Create(ctx, agent)
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Deleting the Agent while an IPRuleConfig of the edge pool exists")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "iprc-10-0-0-63.edge", Labels: map[string]string{"managed-by": "ip-rule-operator"}},
+				Spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.63", Table: 100, Priority: 1000,
+					State: apiv1alpha1.StateAbsent, AgentPool: poolName},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+			defer func() { Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cfg))).To(Succeed()) }()
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, agent)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, agent)).To(Succeed())
+			Expect(agent.Finalizers).To(ContainElement(cleanupFinalizer))
+			readyCond := findCondition(agent.Status.Conditions, string(apiv1alpha1.AgentConditionReady))
+			Expect(readyCond).NotTo(BeNil())
+			Expect(readyCond.Reason).To(Equal("TearingDown"))
+			Expect(readyCond.Message).To(ContainSubstring("removal of 1 IPRuleConfigs"))
+
+			By("Deleting the IPRuleConfig of the edge pool")
+			Expect(k8sClient.Delete(ctx, cfg)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
 
-			By("Verifying creation is rejected")
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("Agent resource name must be 'agent'"))
+			err = k8sClient.Get(ctx, typeNamespacedName, agent)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
 		})
 	})
 })
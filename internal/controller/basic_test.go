@@ -17,11 +17,22 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"errors"
+	"math"
 	"net/netip"
+	"slices"
 	"testing"
+	"time"
 
 	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 // TestBuildDesiredEntryMap tests the IP rule entry map building logic
@@ -55,12 +66,12 @@ func TestBuildDesiredEntryMap(t *testing.T) {
 	lbIP1, _ := netip.ParseAddr("10.0.0.5")  // Matches both rules
 	lbIP2, _ := netip.ParseAddr("10.0.0.50") // Matches only rule1
 
-	svcIPSet := map[netip.Addr][]netip.Addr{
-		clusterIP: {lbIP1, lbIP2},
+	svcIPSet := map[netip.Addr]serviceVIP{
+		clusterIP: {LBIPs: []netip.Addr{lbIP1, lbIP2}},
 	}
 
 	// Build entry map
-	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet)
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
 
 	// Test: Should have 2 entries (one per unique combination)
 	if len(entryMap) != 2 {
@@ -84,6 +95,1041 @@ func TestBuildDesiredEntryMap(t *testing.T) {
 	}
 }
 
+// TestBuildDesiredEntryMapIPv6 tests that CIDRs only match ingress IPs of their own family
+func TestBuildDesiredEntryMapIPv6(t *testing.T) {
+	r := &IPRuleReconciler{}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule-v4"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule-v6"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "2001:db8:1::/64", Table: 200, Priority: 2000},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule-v6-specific"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "2001:db8:1::/120", Table: 200, Priority: 2000},
+			},
+		},
+	}
+
+	// Dual-stack service: one ClusterIP per family, each paired with the ingress IP of its family
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"):  {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+		netip.MustParseAddr("fd00:10:96::a"): {LBIPs: []netip.Addr{netip.MustParseAddr("2001:db8:1::5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	if len(entryMap) != 2 {
+		t.Fatalf("Expected 2 entries (one per family), got %d: %v", len(entryMap), entryMap)
+	}
+	if _, ok := entryMap["192.168.1.10|100|1000"]; !ok {
+		t.Errorf("Expected IPv4 entry, got %v", entryMap)
+	}
+	entry, ok := entryMap["fd00:10:96::a|200|2000"]
+	if !ok {
+		t.Fatalf("Expected IPv6 entry, got %v", entryMap)
+	}
+	if entry.Owner.Name != "rule-v6-specific" {
+		t.Errorf("Expected most specific IPv6 rule to win, got %s", entry.Owner.Name)
+	}
+}
+
+// TestBuildDesiredEntryMapSelectors tests that namespace and service selectors scope an IPRule
+func TestBuildDesiredEntryMapSelectors(t *testing.T) {
+	r := &IPRuleReconciler{}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"},
+				Spec: apiv1alpha1.IPRuleSpec{
+					Cidr: "10.0.0.0/24", Table: 100, Priority: 1000,
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "a"}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "web"},
+				Spec: apiv1alpha1.IPRuleSpec{
+					Cidr: "10.0.0.0/24", Table: 200, Priority: 2000,
+					ServiceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}},
+					}},
+				},
+			},
+		},
+	}
+
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {
+			Namespace:       "tenant-a",
+			NamespaceLabels: map[string]string{"tenant": "a"},
+			Labels:          map[string]string{"app": "db"},
+			LBIPs:           []netip.Addr{netip.MustParseAddr("10.0.0.5")},
+		},
+		netip.MustParseAddr("192.168.1.11"): {
+			Namespace:       "tenant-b",
+			NamespaceLabels: map[string]string{"tenant": "b"},
+			Labels:          map[string]string{"app": "web"},
+			LBIPs:           []netip.Addr{netip.MustParseAddr("10.0.0.6")},
+		},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	if len(entryMap) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(entryMap), entryMap)
+	}
+	if _, ok := entryMap["192.168.1.10|100|1000"]; !ok {
+		t.Errorf("Expected tenant-a service to be selected by namespace, got %v", entryMap)
+	}
+	if _, ok := entryMap["192.168.1.11|200|2000"]; !ok {
+		t.Errorf("Expected web service to be selected by labels, got %v", entryMap)
+	}
+
+	status := computeRuleStatus(&ipRules.Items[0], svcIPSet, true, entryMap, nil)
+	if status.MatchedServices != 1 {
+		t.Errorf("Expected 1 matched service for tenant-a, got %d", status.MatchedServices)
+	}
+}
+
+// TestBuildDesiredEntryMapAddressSources tests that only the configured address sources match
+func TestBuildDesiredEntryMapAddressSources(t *testing.T) {
+	r := &IPRuleReconciler{}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "external"},
+				Spec: apiv1alpha1.IPRuleSpec{
+					Cidr: "10.1.0.0/24", Table: 200, Priority: 2000,
+					AddressSources: []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceExternalIP, apiv1alpha1.AddressSourceLoadBalancerIP},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+				Spec: apiv1alpha1.IPRuleSpec{
+					Cidr: "192.168.2.0/24", Table: 300, Priority: 3000,
+					AddressSources: []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceClusterIP},
+				},
+			},
+		},
+	}
+
+	svcIPSet := map[netip.Addr]serviceVIP{
+		// ExternalIP in the default rule's CIDR must not match without the ExternalIP source
+		netip.MustParseAddr("192.168.1.10"): {ExternalIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+		netip.MustParseAddr("192.168.1.11"): {ExternalIPs: []netip.Addr{netip.MustParseAddr("10.1.0.5")}},
+		netip.MustParseAddr("192.168.1.12"): {RequestedIPs: []netip.Addr{netip.MustParseAddr("10.1.0.6")}},
+		netip.MustParseAddr("192.168.2.13"): {},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	want := []string{"192.168.1.11|200|2000", "192.168.1.12|200|2000", "192.168.2.13|300|3000"}
+	if len(entryMap) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %v", len(want), len(entryMap), entryMap)
+	}
+	for _, key := range want {
+		if _, ok := entryMap[key]; !ok {
+			t.Errorf("Expected entry %s, got %v", key, entryMap)
+		}
+	}
+}
+
+// TestBuildDesiredEntryMapRuleSelector tests that the rule selector is carried into the entries
+func TestBuildDesiredEntryMapRuleSelector(t *testing.T) {
+	r := &IPRuleReconciler{}
+	mark := int64(0x10)
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{{
+			ObjectMeta: metav1.ObjectMeta{Name: "marked"},
+			Spec: apiv1alpha1.IPRuleSpec{
+				Cidr: "10.0.0.0/24", Table: 100, Priority: 1000,
+				RuleSelector: apiv1alpha1.RuleSelector{FwMark: &mark, IIF: "eth1", Dst: "172.16.0.0/12"},
+			},
+		}},
+	}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entry, ok := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)["192.168.1.10|100|1000|fwmark=0x10/0xffffffff|iif=eth1|to=172.16.0.0/12"]
+	if !ok {
+		t.Fatal("Expected entry for 192.168.1.10")
+	}
+	if entry.Selector.FwMark == nil || *entry.Selector.FwMark != mark || entry.Selector.IIF != "eth1" || entry.Selector.Dst != "172.16.0.0/12" {
+		t.Errorf("Expected rule selector to be copied, got %+v", entry.Selector)
+	}
+}
+
+// TestBuildDesiredEntryMapAgentPools tests that every agent pool gets entries of its own
+func TestBuildDesiredEntryMapAgentPools(t *testing.T) {
+	r := &IPRuleReconciler{}
+	rule := func(name, pool string, table int) apiv1alpha1.IPRule {
+		return apiv1alpha1.IPRule{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: table, Priority: 1000, AgentPool: pool},
+		}
+	}
+	ipRules := &apiv1alpha1.IPRuleList{Items: []apiv1alpha1.IPRule{
+		rule("default", "", 100),
+		rule("explicit-default", apiv1alpha1.DefaultAgentPool, 100),
+		rule("uplink-a", "pool-a", 100),
+		rule("uplink-b", "pool-b", 200),
+	}}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	want := map[string]string{
+		"192.168.1.10|100|1000":             "",
+		"192.168.1.10|100|1000|pool=pool-a": "pool-a",
+		"192.168.1.10|200|1000|pool=pool-b": "pool-b",
+	}
+	if len(entryMap) != len(want) {
+		t.Fatalf("Expected %d entries, got %v", len(want), entryMap)
+	}
+	for key, pool := range want {
+		if e, ok := entryMap[key]; !ok || e.Pool != pool {
+			t.Errorf("Expected entry %s of pool %q, got %+v", key, pool, e)
+		}
+	}
+}
+
+// TestBuildDesiredEntryMapNodeSelector tests that IPRules differing in their node selector yield
+// entries of their own, so the most specific CIDR does not take over the nodes of the other rule
+func TestBuildDesiredEntryMapNodeSelector(t *testing.T) {
+	r := &IPRuleReconciler{}
+	gateways := &metav1.LabelSelector{MatchLabels: map[string]string{"role": "egress"}}
+	ipRules := &apiv1alpha1.IPRuleList{Items: []apiv1alpha1.IPRule{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "all-nodes"},
+			Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/16", Table: 100, Priority: 1000},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "egress"},
+			Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000, NodeSelector: gateways},
+		},
+	}}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	if len(entryMap) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", entryMap)
+	}
+	if e, ok := entryMap["192.168.1.10|100|1000"]; !ok || e.Owner.Name != "all-nodes" || e.Nodes != nil {
+		t.Errorf("Expected the all-nodes entry without node selector, got %+v", e)
+	}
+	e, ok := entryMap["192.168.1.10|100|1000|nodes=role=egress"]
+	if !ok || e.Owner.Name != "egress" || !equality.Semantic.DeepEqual(e.Nodes, gateways) {
+		t.Errorf("Expected the egress entry with node selector %v, got %+v", gateways, entryMap)
+	}
+}
+
+// TestBuildDesiredEntryMapFwMarks tests that IPRules differing only in their fwmark get an
+// IPRuleConfig each
+func TestBuildDesiredEntryMapFwMarks(t *testing.T) {
+	r := &IPRuleReconciler{}
+	mark1, mark2 := int64(0x1), int64(0x2)
+	ipRules := &apiv1alpha1.IPRuleList{Items: []apiv1alpha1.IPRule{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "mark-1"},
+			Spec: apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000,
+				RuleSelector: apiv1alpha1.RuleSelector{FwMark: &mark1}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "mark-2"},
+			Spec: apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000,
+				RuleSelector: apiv1alpha1.RuleSelector{FwMark: &mark2}},
+		},
+	}}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	if len(entryMap) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", entryMap)
+	}
+	names := map[string]bool{}
+	for key, e := range entryMap {
+		if want := "192.168.1.10|100|1000|" + ruleSelectorKey(&e.Selector); key != want {
+			t.Errorf("Expected key %q for %s, got %q", want, e.Owner.Name, key)
+		}
+		names[configName(e.IP, e.Pool, key)] = true
+	}
+	if len(names) != 2 {
+		t.Errorf("Expected 2 IPRuleConfig names, got %v", names)
+	}
+	if e := entryMap["192.168.1.10|100|1000|fwmark=0x1/0xffffffff"]; e.Owner == nil || e.Owner.Name != "mark-1" {
+		t.Errorf("Expected the fwmark 0x1 entry of mark-1, got %+v", entryMap)
+	}
+}
+
+// TestBuildDesiredEntryMapActions tests that a lookup and a blackhole rule of one service IP and
+// priority do not collapse into one IPRuleConfig
+func TestBuildDesiredEntryMapActions(t *testing.T) {
+	r := &IPRuleReconciler{}
+	ipRules := &apiv1alpha1.IPRuleList{Items: []apiv1alpha1.IPRule{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "lookup"},
+			Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "blackhole"},
+			Spec: apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000,
+				RuleAction: apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionBlackhole}},
+		},
+	}}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	want := map[string]string{
+		"192.168.1.10|100|1000":                  "lookup",
+		"192.168.1.10|100|1000|action=blackhole": "blackhole",
+	}
+	if len(entryMap) != len(want) {
+		t.Fatalf("Expected %d entries, got %v", len(want), entryMap)
+	}
+	for key, owner := range want {
+		if e, ok := entryMap[key]; !ok || e.Owner.Name != owner {
+			t.Errorf("Expected entry %s of %s, got %+v", key, owner, entryMap)
+		}
+	}
+}
+
+// TestRuleActionKey tests that only a plain lookup has an empty action key
+func TestRuleActionKey(t *testing.T) {
+	suppress := int32(0)
+	tests := []struct {
+		action apiv1alpha1.RuleAction
+		want   string
+	}{
+		{apiv1alpha1.RuleAction{}, ""},
+		{apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionLookup}, ""},
+		{apiv1alpha1.RuleAction{SuppressPrefixLength: &suppress}, "suppress_prefixlength=0"},
+		{apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionGoto, GotoPriority: 2000}, "goto=2000"},
+		{apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionUnreachable}, "action=unreachable"},
+		{apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionProhibit}, "action=prohibit"},
+	}
+	for _, tt := range tests {
+		if got := ruleActionKey(&tt.action); got != tt.want {
+			t.Errorf("ruleActionKey(%+v) = %q, want %q", tt.action, got, tt.want)
+		}
+	}
+}
+
+// TestRuleSelectorKey tests that equivalent traffic selectors share a key
+func TestRuleSelectorKey(t *testing.T) {
+	mark, fullMask := int64(0x10), int64(math.MaxUint32)
+	tests := []struct {
+		name string
+		a, b apiv1alpha1.RuleSelector
+		same bool
+	}{
+		{"empty", apiv1alpha1.RuleSelector{}, apiv1alpha1.RuleSelector{UIDRange: &apiv1alpha1.UIDRange{End: math.MaxUint32}}, true},
+		{"implicit mask", apiv1alpha1.RuleSelector{FwMark: &mark}, apiv1alpha1.RuleSelector{FwMark: &mark, FwMask: &fullMask}, true},
+		{"unmasked dst", apiv1alpha1.RuleSelector{Dst: "192.168.1.7/24"}, apiv1alpha1.RuleSelector{Dst: "192.168.1.0/24"}, true},
+		{"single port", apiv1alpha1.RuleSelector{DstPort: &apiv1alpha1.PortRange{Start: 443}},
+			apiv1alpha1.RuleSelector{DstPort: &apiv1alpha1.PortRange{Start: 443, End: 443}}, true},
+		{"source vs destination port", apiv1alpha1.RuleSelector{SrcPort: &apiv1alpha1.PortRange{Start: 443}},
+			apiv1alpha1.RuleSelector{DstPort: &apiv1alpha1.PortRange{Start: 443}}, false},
+		{"inverted", apiv1alpha1.RuleSelector{IIF: "eth0"}, apiv1alpha1.RuleSelector{IIF: "eth0", Invert: true}, false},
+		{"tos", apiv1alpha1.RuleSelector{}, apiv1alpha1.RuleSelector{TOS: 0x10}, false},
+	}
+	for _, tt := range tests {
+		if got := ruleSelectorKey(&tt.a) == ruleSelectorKey(&tt.b); got != tt.same {
+			t.Errorf("%s: keys %q and %q, want equal = %v", tt.name, ruleSelectorKey(&tt.a), ruleSelectorKey(&tt.b), tt.same)
+		}
+	}
+}
+
+// TestSelectorKey tests that node selectors written in a different order produce the same entry key
+func TestSelectorKey(t *testing.T) {
+	a := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "role", Operator: metav1.LabelSelectorOpIn, Values: []string{"egress"}},
+		{Key: "zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"b", "a"}},
+	}}
+	b := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "zone", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"}},
+		{Key: "role", Operator: metav1.LabelSelectorOpIn, Values: []string{"egress"}},
+	}}
+	if selectorKey(a) != selectorKey(b) {
+		t.Errorf("Expected equal keys, got %q and %q", selectorKey(a), selectorKey(b))
+	}
+	if k := selectorKey(&metav1.LabelSelector{}); k != "" {
+		t.Errorf("Expected an empty key for a selector matching everything, got %q", k)
+	}
+	if k := selectorKey(nil); k != "" {
+		t.Errorf("Expected an empty key for no selector, got %q", k)
+	}
+}
+
+// fakeResolver resolves hostnames from a static map
+type fakeResolver map[string][]netip.Addr
+
+func (f fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	return f[host], nil
+}
+
+// TestCollectServiceVIPsSources tests that every address source is collected per family
+func TestCollectServiceVIPsSources(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "lb", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:           corev1.ServiceTypeLoadBalancer,
+			ClusterIP:      "192.168.1.10",
+			ClusterIPs:     []string{"192.168.1.10"},
+			LoadBalancerIP: "10.0.0.7",
+			ExternalIPs:    []string{"10.0.0.8", "fd00::8"},
+		},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+			{IP: "10.0.0.5"},
+			{Hostname: "lb.example.com"},
+		}}},
+	}
+	internal := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "internal", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "192.168.1.11"},
+	}
+	r := &IPRuleReconciler{
+		Client:   fake.NewClientBuilder().WithObjects(svc, internal).Build(),
+		Resolver: fakeResolver{"lb.example.com": {netip.MustParseAddr("10.0.0.6"), netip.MustParseAddr("fd00::6")}},
+	}
+
+	svcIPSet, err := r.collectServiceVIPs(context.Background(), true)
+	if err != nil {
+		t.Fatalf("collectServiceVIPs() error = %v", err)
+	}
+	if len(svcIPSet) != 2 {
+		t.Fatalf("Expected 2 ClusterIPs, got %d: %v", len(svcIPSet), svcIPSet)
+	}
+	v := svcIPSet[netip.MustParseAddr("192.168.1.10")]
+	checks := map[apiv1alpha1.AddressSource]string{
+		apiv1alpha1.AddressSourceIngressIP:       "10.0.0.5",
+		apiv1alpha1.AddressSourceIngressHostname: "10.0.0.6",
+		apiv1alpha1.AddressSourceLoadBalancerIP:  "10.0.0.7",
+		apiv1alpha1.AddressSourceExternalIP:      "10.0.0.8",
+	}
+	for src, want := range checks {
+		got := v.addresses(netip.MustParseAddr("192.168.1.10"), src)
+		if len(got) != 1 || got[0] != netip.MustParseAddr(want) {
+			t.Errorf("Expected %s addresses [%s], got %v", src, want, got)
+		}
+	}
+	if _, ok := svcIPSet[netip.MustParseAddr("192.168.1.11")]; !ok {
+		t.Error("Expected ClusterIP-only service to be collected for the ClusterIP source")
+	}
+}
+
+// flakyResolver fails the lookups of the hostnames in down and blocks on the ones in hang
+// until the lookup deadline
+type flakyResolver struct {
+	fakeResolver
+	down map[string]bool
+	hang map[string]bool
+}
+
+func (f *flakyResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if f.hang[host] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.down[host] {
+		return nil, errors.New("no such host")
+	}
+	return f.fakeResolver.LookupNetIP(ctx, network, host)
+}
+
+// TestResolveHostnames tests that failed lookups keep the last known addresses and that unused
+// hostnames leave the cache
+func TestResolveHostnames(t *testing.T) {
+	resolver := &flakyResolver{fakeResolver: fakeResolver{
+		"a.example.com": {netip.MustParseAddr("10.0.0.1")},
+		"b.example.com": {netip.MustParseAddr("10.0.0.2")},
+	}}
+	r := &IPRuleReconciler{Resolver: resolver}
+	ctx := context.Background()
+
+	got := r.resolveHostnames(ctx, []string{"a.example.com", "b.example.com"})
+	if len(got["a.example.com"]) != 1 || len(got["b.example.com"]) != 1 {
+		t.Fatalf("Expected both hostnames resolved, got %v", got)
+	}
+
+	// a fails, b hangs until the deadline: both keep their addresses
+	resolver.down = map[string]bool{"a.example.com": true}
+	resolver.hang = map[string]bool{"b.example.com": true}
+	start := time.Now()
+	got = r.resolveHostnames(ctx, []string{"a.example.com", "b.example.com", "c.example.com"})
+	if d := time.Since(start); d > 2*hostnameLookupTimeout {
+		t.Errorf("Expected the lookups to be bounded by %s, took %s", hostnameLookupTimeout, d)
+	}
+	if len(got["a.example.com"]) != 1 || got["a.example.com"][0] != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf("Expected a.example.com to keep its last known address, got %v", got["a.example.com"])
+	}
+	if len(got["b.example.com"]) != 1 {
+		t.Errorf("Expected b.example.com to keep its last known address, got %v", got["b.example.com"])
+	}
+	if len(got["c.example.com"]) != 0 {
+		t.Errorf("Expected no addresses for the unknown c.example.com, got %v", got["c.example.com"])
+	}
+
+	// a is no longer used and leaves the cache, so a later failure yields nothing
+	resolver.hang = nil
+	r.resolveHostnames(ctx, []string{"b.example.com"})
+	if got = r.resolveHostnames(ctx, []string{"a.example.com"}); len(got["a.example.com"]) != 0 {
+		t.Errorf("Expected a.example.com to be dropped from the cache, got %v", got["a.example.com"])
+	}
+}
+
+// TestConfigName tests the IPRuleConfig name encoding for both families and agent pools
+func TestConfigName(t *testing.T) {
+	tests := []struct {
+		ip    string
+		pool  string
+		table int
+		vrf   string
+		want  string
+	}{
+		{"10.96.0.10", "", 100, "", "iprc-10-96-0-10-bf73118a"},
+		{"fd00:10:96::a", "", 100, "", "iprc-fd00-0010-0096-0000-0000-0000-0000-000a-c56d88ca"},
+		{"::1", "", 100, "", "iprc-0000-0000-0000-0000-0000-0000-0000-0001-48d2fc22"},
+		{"FD00::A", "", 100, "", "iprc-fd00-0000-0000-0000-0000-0000-0000-000a-6030b3e7"},
+		{"10.96.0.10", "edge", 100, "", "iprc-10-96-0-10-728cba12.edge"},
+		{"fd00::a", "uplink-b", 0, "vrf-blue", "iprc-fd00-0000-0000-0000-0000-0000-0000-000a-6daa7fbd.uplink-b"},
+		// Entries of one address differing in table, VRF or priority get configs of their own
+		{"10.96.0.10", "", 200, "", "iprc-10-96-0-10-c6a928da"},
+		{"10.96.0.10", "", 0, "vrf-blue", "iprc-10-96-0-10-e255c8ee"},
+	}
+	for _, tt := range tests {
+		ip := netip.MustParseAddr(tt.ip)
+		name := configName(ip, tt.pool, entryKey(tt.pool, ip.String(), tt.table, tt.vrf, 1000, nil, &apiv1alpha1.RuleSelector{}, &apiv1alpha1.RuleAction{}))
+		if name != tt.want {
+			t.Errorf("configName(%s) = %s, want %s", tt.ip, name, tt.want)
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			t.Errorf("configName(%s) = %s is not a valid object name: %v", tt.ip, name, errs)
+		}
+	}
+	ip := netip.MustParseAddr("10.96.0.10")
+	if name := configName(ip, "", entryKey("", ip.String(), 100, "", 2000, nil, &apiv1alpha1.RuleSelector{}, &apiv1alpha1.RuleAction{})); name != "iprc-10-96-0-10-4eaa23a5" {
+		t.Errorf("configName() of priority 2000 = %s, want iprc-10-96-0-10-4eaa23a5", name)
+	}
+}
+
+// TestCollectServiceVIPsDualStack tests that dual-stack services yield one ClusterIP per family
+func TestCollectServiceVIPsDualStack(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dual", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:       corev1.ServiceTypeLoadBalancer,
+			ClusterIP:  "fd00:10:96::a",
+			ClusterIPs: []string{"fd00:10:96::a", "10.96.0.10"},
+		},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+			{IP: "10.0.0.5"},
+			{IP: "2001:db8:1::5"},
+		}}},
+	}
+	singleStack := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "v4only", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ClusterIP: "10.96.0.11"},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+			{IP: "10.0.0.6"},
+			{IP: "2001:db8:1::6"}, // no IPv6 ClusterIP to pair with
+		}}},
+	}
+	r := &IPRuleReconciler{Client: fake.NewClientBuilder().WithObjects(svc, singleStack).Build()}
+
+	svcIPSet, err := r.collectServiceVIPs(context.Background(), false)
+	if err != nil {
+		t.Fatalf("collectServiceVIPs() error = %v", err)
+	}
+	want := map[string]string{
+		"10.96.0.10":    "10.0.0.5",
+		"fd00:10:96::a": "2001:db8:1::5",
+		"10.96.0.11":    "10.0.0.6",
+	}
+	if len(svcIPSet) != len(want) {
+		t.Fatalf("Expected %d ClusterIPs, got %v", len(want), svcIPSet)
+	}
+	for clusterIP, lbIP := range want {
+		got := svcIPSet[netip.MustParseAddr(clusterIP)].LBIPs
+		if len(got) != 1 || got[0] != netip.MustParseAddr(lbIP) {
+			t.Errorf("ClusterIP %s: expected [%s], got %v", clusterIP, lbIP, got)
+		}
+	}
+}
+
+// TestBuildDesiredEntryMapDefaults tests that unset table/priority fall back to the operator defaults
+func TestBuildDesiredEntryMapDefaults(t *testing.T) {
+	r := &IPRuleReconciler{DefaultTable: 150, DefaultPriority: 1500}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24"},
+			},
+		},
+	}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	if _, ok := entryMap["192.168.1.10|150|1500"]; !ok {
+		t.Errorf("Expected entry with default table/priority, got %v", entryMap)
+	}
+}
+
+// TestComputeRuleStatus tests the IPRule status derived from services and desired entries
+func TestComputeRuleStatus(t *testing.T) {
+	r := &IPRuleReconciler{}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule1", Generation: 2},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", Table: 100, Priority: 1000},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "typo", Generation: 1},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/33", Table: 200, Priority: 2000},
+			},
+		},
+	}
+
+	svc1, _ := netip.ParseAddr("192.168.1.10")
+	svc2, _ := netip.ParseAddr("192.168.1.11")
+	svc3, _ := netip.ParseAddr("192.168.1.12")
+	svcIPSet := map[netip.Addr]serviceVIP{
+		svc1: {Namespace: "default", Name: "svc1", LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.6")}}, // two LB IPs, one service
+		svc2: {Namespace: "default", Name: "svc2", LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.7")}},
+		svc3: {Namespace: "default", Name: "svc3", LBIPs: []netip.Addr{netip.MustParseAddr("172.16.0.1")}}, // outside the CIDR
+	}
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+
+	// Valid rule: two services, two configs, Ready
+	status := computeRuleStatus(&ipRules.Items[0], svcIPSet, true, entryMap, nil)
+	if status.MatchedServices != 2 {
+		t.Errorf("Expected 2 matched services, got %d", status.MatchedServices)
+	}
+	if status.ConfigCount != 2 {
+		t.Errorf("Expected 2 configs, got %d", status.ConfigCount)
+	}
+
+	// A dual-stack service has an entry per ClusterIP and counts once, whichever family matches
+	dual := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.20"):   {Namespace: "default", Name: "dual", LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.20")}},
+		netip.MustParseAddr("fd00:10:96::20"): {Namespace: "default", Name: "dual", LBIPs: []netip.Addr{netip.MustParseAddr("2001:db8::20")}},
+	}
+	dualRule := &apiv1alpha1.IPRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "dual"},
+		Spec: apiv1alpha1.IPRuleSpec{Cidr: "0.0.0.0/0", Table: 100, Priority: 1000,
+			AddressSources: []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceIngressIP, apiv1alpha1.AddressSourceClusterIP}},
+	}
+	dualV6 := dualRule.DeepCopy()
+	dualV6.Spec.Cidr = "::/0"
+	for _, rule := range []*apiv1alpha1.IPRule{dualRule, dualV6} {
+		if status := computeRuleStatus(rule, dual, true, nil, nil); status.MatchedServices != 1 {
+			t.Errorf("Expected the dual-stack service to count once for %s, got %d", rule.Spec.Cidr, status.MatchedServices)
+		}
+	}
+	// Matched through both of its entries, the service still counts once
+	both := map[netip.Addr]serviceVIP{}
+	for clusterIP, v := range dual {
+		v.ExternalIPs = []netip.Addr{netip.MustParseAddr("10.0.0.21")}
+		both[clusterIP] = v
+	}
+	extRule := dualRule.DeepCopy()
+	extRule.Spec.AddressSources = []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceExternalIP}
+	if status := computeRuleStatus(extRule, both, true, nil, nil); status.MatchedServices != 1 {
+		t.Errorf("Expected a service matched through both ClusterIP entries to count once, got %d", status.MatchedServices)
+	}
+	if status.ObservedGeneration != 2 {
+		t.Errorf("Expected observedGeneration 2, got %d", status.ObservedGeneration)
+	}
+	if cond := findCondition(status.Conditions, string(apiv1alpha1.IPRuleConditionReady)); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("Expected Ready=True, got %v", cond)
+	}
+	if cond := findCondition(status.Conditions, string(apiv1alpha1.IPRuleConditionInvalidCIDR)); cond == nil || cond.Status != metav1.ConditionFalse {
+		t.Errorf("Expected InvalidCIDR=False, got %v", cond)
+	}
+
+	// Invalid CIDR: InvalidCIDR=True and Ready=False instead of being skipped silently
+	status = computeRuleStatus(&ipRules.Items[1], svcIPSet, true, entryMap, nil)
+	if status.MatchedServices != 0 || status.ConfigCount != 0 {
+		t.Errorf("Expected no matches for invalid CIDR, got %d/%d", status.MatchedServices, status.ConfigCount)
+	}
+	if cond := findCondition(status.Conditions, string(apiv1alpha1.IPRuleConditionInvalidCIDR)); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Errorf("Expected InvalidCIDR=True, got %v", cond)
+	}
+	if cond := findCondition(status.Conditions, string(apiv1alpha1.IPRuleConditionReady)); cond == nil || cond.Reason != "InvalidCIDR" {
+		t.Errorf("Expected Ready reason InvalidCIDR, got %v", cond)
+	}
+}
+
+// TestBuildDesiredEntryMapTableName tests that table names resolve through the RoutingTables
+func TestBuildDesiredEntryMapTableName(t *testing.T) {
+	r := &IPRuleReconciler{DefaultTable: 100, DefaultPriority: 1000}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "named"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", TableName: "uplink-a", Priority: 2000},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "missing"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.1.0.0/24", TableName: "uplink-b", Priority: 3000},
+			},
+		},
+	}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+		netip.MustParseAddr("192.168.1.11"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.1.0.5")}},
+	}
+	tableIDs := map[string]int{"uplink-a": 110}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, tableIDs)
+	if len(entryMap) != 1 {
+		t.Fatalf("Expected 1 entry, got %d: %v", len(entryMap), entryMap)
+	}
+	if _, ok := entryMap["192.168.1.10|110|2000"]; !ok {
+		t.Errorf("Expected the named table to resolve to 110, got %v", entryMap)
+	}
+
+	_, found := r.ruleTable(&ipRules.Items[1], tableIDs)
+	if found {
+		t.Fatal("Expected uplink-b to be unresolved")
+	}
+	status := computeRuleStatus(&ipRules.Items[1], svcIPSet, found, entryMap, nil)
+	if cond := findCondition(status.Conditions, string(apiv1alpha1.IPRuleConditionReady)); cond == nil || cond.Reason != "TableNotFound" {
+		t.Errorf("Expected Ready reason TableNotFound, got %v", cond)
+	}
+}
+
+// TestBuildDesiredEntryMapVRF tests that VRF rules leave the table to the agents
+func TestBuildDesiredEntryMapVRF(t *testing.T) {
+	r := &IPRuleReconciler{DefaultTable: 100, DefaultPriority: 1000}
+
+	ipRules := &apiv1alpha1.IPRuleList{
+		Items: []apiv1alpha1.IPRule{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "blue"},
+				Spec:       apiv1alpha1.IPRuleSpec{Cidr: "10.0.0.0/24", VRF: "vrf-blue"},
+			},
+		},
+	}
+	svcIPSet := map[netip.Addr]serviceVIP{
+		netip.MustParseAddr("192.168.1.10"): {LBIPs: []netip.Addr{netip.MustParseAddr("10.0.0.5")}},
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, nil)
+	entry, ok := entryMap["192.168.1.10|vrf=vrf-blue|1000"]
+	if !ok {
+		t.Fatalf("Expected an entry for vrf-blue, got %v", entryMap)
+	}
+	if entry.Table != 0 || entry.VRF != "vrf-blue" {
+		t.Errorf("Expected table 0 and vrf vrf-blue, got %d/%q", entry.Table, entry.VRF)
+	}
+}
+
+// TestPendingNodes tests which nodes still have to acknowledge the removal of an absent config
+func TestPendingNodes(t *testing.T) {
+	cfg := &apiv1alpha1.IPRuleConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "iprc-10-0-0-1", Generation: 3},
+		Spec:       apiv1alpha1.IPRuleConfigSpec{ServiceIP: "10.0.0.1", State: apiv1alpha1.StateAbsent},
+		Status: apiv1alpha1.IPRuleConfigStatus{Nodes: []apiv1alpha1.NodeRuleStatus{
+			{NodeName: "node-a", State: apiv1alpha1.NodeStateRemoved, ObservedGeneration: 3},
+			{NodeName: "node-b", State: apiv1alpha1.NodeStateApplied, ObservedGeneration: 2},
+			{NodeName: "node-c", State: apiv1alpha1.NodeStateRemoved, ObservedGeneration: 1}, // removal of an earlier absence
+		}},
+	}
+
+	pending := pendingNodes(cfg, []string{"node-a", "node-b", "node-c", "node-d"})
+	if want := []string{"node-b", "node-c", "node-d"}; !slices.Equal(pending, want) {
+		t.Errorf("Expected pending nodes %v, got %v", want, pending)
+	}
+	if pending := pendingNodes(cfg, []string{"node-a"}); len(pending) != 0 {
+		t.Errorf("Expected no pending nodes, got %v", pending)
+	}
+}
+
+// TestAgentNodes tests which nodes the absent-config cleanup waits for
+func TestAgentNodes(t *testing.T) {
+	agentPod := func(name, node string, ready bool, mode apiv1alpha1.AgentMode) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default",
+				Labels: map[string]string{"app": "iprule-agent", agentModeLabel: string(mode)}},
+			Spec: corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		}
+	}
+	node := func(name string, labels map[string]string, taints ...corev1.Taint) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}, Spec: corev1.NodeSpec{Taints: taints}}
+	}
+	agent := &apiv1alpha1.Agent{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default"},
+		Spec: apiv1alpha1.AgentSpec{NodeLabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "node-role.kubernetes.io/control-plane", Operator: metav1.LabelSelectorOpDoesNotExist},
+		}}},
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	edge := &apiv1alpha1.Agent{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default"},
+		Spec:       apiv1alpha1.AgentSpec{NodeSelector: map[string]string{"pool": "edge"}},
+	}
+	edgePod := agentPod("edge-f", "node-f", true, apiv1alpha1.AgentModeEnforce)
+	edgePod.Labels[agentPoolLabel] = "edge"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(agent, edge, edgePod,
+		node("node-a", nil), node("node-b", nil), node("node-c", map[string]string{"egress-gateway": ""}), node("node-f", map[string]string{"pool": "edge"}),
+		node("node-d", map[string]string{"node-role.kubernetes.io/control-plane": ""}),
+		node("node-e", nil, corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoExecute}),
+		agentPod("agent-a", "node-a", true, apiv1alpha1.AgentModeEnforce),
+		agentPod("agent-b", "node-b", true, apiv1alpha1.AgentModeAudit),
+		agentPod("agent-c", "node-c", false, apiv1alpha1.AgentModeEnforce),
+		agentPod("agent-d", "node-d", true, apiv1alpha1.AgentModeEnforce),
+		agentPod("agent-e", "node-e", true, apiv1alpha1.AgentModeEnforce),
+		agentPod("agent-gone", "node-gone", true, apiv1alpha1.AgentModeEnforce),
+	).Build()
+	r := &IPRuleConfigReconciler{Client: c}
+
+	// agent-d and agent-e run on nodes the DaemonSet no longer places an agent on; the pods
+	// without pool label belong to the default pool
+	nodes, ready, err := r.agentNodes(context.Background(), &apiv1alpha1.IPRuleConfigSpec{})
+	if err != nil {
+		t.Fatalf("agentNodes() error = %v", err)
+	}
+	if len(nodes) != 6 || nodes["node-gone"] {
+		t.Errorf("Expected the 6 cluster nodes, got %v", nodes)
+	}
+	if want := []string{"node-a"}; !slices.Equal(ready, want) {
+		t.Errorf("Expected ready agent nodes %v, got %v", want, ready)
+	}
+
+	// Other pools only wait for their own agents
+	_, ready, err = r.agentNodes(context.Background(), &apiv1alpha1.IPRuleConfigSpec{AgentPool: "edge"})
+	if err != nil {
+		t.Fatalf("agentNodes() error = %v", err)
+	}
+	if want := []string{"node-f"}; !slices.Equal(ready, want) {
+		t.Errorf("Expected ready agent nodes of pool edge %v, got %v", want, ready)
+	}
+
+	// A config with a node selector only targets the selected nodes
+	nodes, ready, err = r.agentNodes(context.Background(), &apiv1alpha1.IPRuleConfigSpec{
+		NodeSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "egress-gateway", Operator: metav1.LabelSelectorOpExists},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("agentNodes() error = %v", err)
+	}
+	if len(nodes) != 1 || !nodes["node-c"] {
+		t.Errorf("Expected only node-c to be targeted, got %v", nodes)
+	}
+	if len(ready) != 0 {
+		t.Errorf("Expected no ready agent nodes, got %v", ready)
+	}
+}
+
+// TestPlacesAgent tests that the agent placement follows the DaemonSet scheduling rules
+func TestPlacesAgent(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{
+			"kubernetes.io/os": "linux", "zone": "a", "cores": "8",
+		}},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: "gpu", Value: "true", Effect: corev1.TaintEffectNoSchedule},
+			{Key: corev1.TaintNodeNotReady, Effect: corev1.TaintEffectNoExecute},
+		}},
+	}
+	expr := func(key string, op metav1.LabelSelectorOperator, values ...string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: key, Operator: op, Values: values}}}
+	}
+	required := func(reqs ...corev1.NodeSelectorRequirement) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: reqs}},
+		}}}
+	}
+
+	tests := []struct {
+		name    string
+		spec    apiv1alpha1.AgentSpec
+		taints  []corev1.Taint // added to the node
+		want    bool
+		wantErr bool
+	}{
+		{name: "no constraints", want: true},
+		{name: "nodeSelector match", spec: apiv1alpha1.AgentSpec{NodeSelector: map[string]string{"zone": "a"}}, want: true},
+		{name: "nodeSelector mismatch", spec: apiv1alpha1.AgentSpec{NodeSelector: map[string]string{"zone": "b"}}},
+		{name: "In", spec: apiv1alpha1.AgentSpec{NodeLabelSelector: expr("zone", metav1.LabelSelectorOpIn, "a", "b")}, want: true},
+		{name: "NotIn", spec: apiv1alpha1.AgentSpec{NodeLabelSelector: expr("zone", metav1.LabelSelectorOpNotIn, "a")}},
+		{name: "Exists", spec: apiv1alpha1.AgentSpec{NodeLabelSelector: expr("zone", metav1.LabelSelectorOpExists)}, want: true},
+		{name: "DoesNotExist", spec: apiv1alpha1.AgentSpec{NodeLabelSelector: expr("zone", metav1.LabelSelectorOpDoesNotExist)}},
+		{name: "matchLabels", spec: apiv1alpha1.AgentSpec{NodeLabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"zone": "b"}}}},
+		{
+			name: "affinity Gt",
+			spec: apiv1alpha1.AgentSpec{Affinity: required(corev1.NodeSelectorRequirement{Key: "cores", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}})},
+			want: true,
+		},
+		{
+			name: "affinity ANDed with selector",
+			spec: apiv1alpha1.AgentSpec{
+				Affinity:          required(corev1.NodeSelectorRequirement{Key: "cores", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}}),
+				NodeLabelSelector: expr("zone", metav1.LabelSelectorOpNotIn, "a"),
+			},
+		},
+		{
+			name: "affinity matchFields",
+			spec: apiv1alpha1.AgentSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{
+					{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"worker-2"}},
+				}}},
+			}}}},
+		},
+		{
+			name:   "untolerated NoExecute taint",
+			taints: []corev1.Taint{{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoExecute}},
+		},
+		{
+			name: "tolerated NoExecute taint",
+			spec: apiv1alpha1.AgentSpec{Tolerations: []corev1.Toleration{
+				{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "infra", Effect: corev1.TaintEffectNoExecute},
+			}},
+			taints: []corev1.Taint{{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoExecute}},
+			want:   true,
+		},
+		{
+			name:    "invalid selector",
+			spec:    apiv1alpha1.AgentSpec{NodeLabelSelector: expr("zone", metav1.LabelSelectorOpIn)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := node.DeepCopy()
+			n.Spec.Taints = append(n.Spec.Taints, tt.taints...)
+			got, err := placesAgent(&apiv1alpha1.Agent{Spec: tt.spec}, n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("placesAgent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("placesAgent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
+
+// TestOverlappingAgent tests the detection of agent pools sharing a node
+func TestOverlappingAgent(t *testing.T) {
+	agent := func(namespace, name string, nodeSelector map[string]string) apiv1alpha1.Agent {
+		return apiv1alpha1.Agent{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       apiv1alpha1.AgentSpec{NodeSelector: nodeSelector},
+		}
+	}
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Labels: map[string]string{"pool": "core"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "gateway-1", Labels: map[string]string{"pool": "edge"}}},
+	}
+	core := agent("default", "agent", map[string]string{"pool": "core"})
+	edge := agent("default", "edge", map[string]string{"pool": "edge"})
+
+	tests := []struct {
+		name   string
+		agents []apiv1alpha1.Agent
+		want   string
+	}{
+		{"disjoint pools", []apiv1alpha1.Agent{core, edge}, ""},
+		{"pool on every node", []apiv1alpha1.Agent{core, agent("default", "all", nil)},
+			"the agents of Agent default/all run on node worker-1 as well"},
+		{"same pool in another namespace", []apiv1alpha1.Agent{core, agent("other", "agent", map[string]string{"pool": "edge"})},
+			"Agent other/agent forms the same pool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := overlappingAgent(&core, tt.agents, nodes)
+			if err != nil {
+				t.Fatalf("overlappingAgent() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("overlappingAgent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAgentAffinity tests that nodeLabelSelector is merged into the required node affinity
+func TestAgentAffinity(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels:      map[string]string{"zone": "a"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "edge", Operator: metav1.LabelSelectorOpExists}},
+	}
+	want := []corev1.NodeSelectorRequirement{
+		{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+		{Key: "edge", Operator: corev1.NodeSelectorOpExists},
+	}
+
+	affinity, err := agentAffinity(&apiv1alpha1.Agent{Spec: apiv1alpha1.AgentSpec{NodeLabelSelector: selector}})
+	if err != nil {
+		t.Fatalf("agentAffinity() error = %v", err)
+	}
+	terms := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || !equality.Semantic.DeepEqual(terms[0].MatchExpressions, want) {
+		t.Errorf("Expected a single term %v, got %v", want, terms)
+	}
+
+	// Every ORed term of spec.affinity gets the selector, spec.affinity itself stays untouched
+	spec := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+		NodeSelectorTerms: []corev1.NodeSelectorTerm{
+			{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "rack", Operator: corev1.NodeSelectorOpIn, Values: []string{"r1"}}}},
+			{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"n1"}}}},
+		},
+	}}}
+	affinity, err = agentAffinity(&apiv1alpha1.Agent{Spec: apiv1alpha1.AgentSpec{Affinity: spec, NodeLabelSelector: selector}})
+	if err != nil {
+		t.Fatalf("agentAffinity() error = %v", err)
+	}
+	terms = affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 2 || len(terms[0].MatchExpressions) != 3 || len(terms[1].MatchExpressions) != 2 {
+		t.Errorf("Expected the selector in both terms, got %v", terms)
+	}
+	if len(spec.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) != 1 {
+		t.Error("Expected spec.affinity not to be modified")
+	}
+
+	// Without a selector spec.affinity is used as is
+	if affinity, _ := agentAffinity(&apiv1alpha1.Agent{Spec: apiv1alpha1.AgentSpec{Affinity: spec}}); affinity != spec {
+		t.Error("Expected spec.affinity to be returned unchanged")
+	}
+}
+
 // TestComputeTemplateHash tests the template hash computation
 func TestComputeTemplateHash(t *testing.T) {
 	agent1 := &apiv1alpha1.Agent{
@@ -116,6 +1162,11 @@ func TestComputeTemplateHash(t *testing.T) {
 	hash1 := computeTemplateHash(agent1, agent1.Spec.Image)
 	hash2 := computeTemplateHash(agent2, agent2.Spec.Image)
 	hash3 := computeTemplateHash(agent3, agent3.Spec.Image)
+	agent4 := agent1.DeepCopy()
+	agent4.Spec.Mode = apiv1alpha1.AgentModeAudit
+	if hash4 := computeTemplateHash(agent4, agent4.Spec.Image); hash4 == hash1 {
+		t.Errorf("Expected a different hash for audit mode, got same hash: %s", hash1)
+	}
 
 	// Same config should produce same hash
 	if hash1 != hash2 {
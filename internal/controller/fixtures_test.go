@@ -0,0 +1,83 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// Fixtures shared by the envtest suites of the controllers.
+
+// ensureNode creates a Node with the given name unless it exists.
+func ensureNode(ctx context.Context, name string) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	Expect(client.IgnoreAlreadyExists(k8sClient.Create(ctx, node))).To(Succeed())
+}
+
+// ensureReadyAgentPod creates a running and ready agent pod on the given node.
+func ensureReadyAgentPod(ctx context.Context, nodeName string) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "iprule-agent-" + nodeName,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "iprule-agent"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []corev1.Container{{Name: "agent", Image: "agent"}},
+		},
+	}
+	err := k8sClient.Create(ctx, pod)
+	if errors.IsAlreadyExists(err) {
+		return
+	}
+	Expect(err).NotTo(HaveOccurred())
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+}
+
+// setNodeStatus records per-node entries on a created IPRuleConfig or RoutingTable, the way the
+// agents report them.
+func setNodeStatus(ctx context.Context, obj client.Object, nodes []apiv1alpha1.NodeRuleStatus) {
+	switch o := obj.(type) {
+	case *apiv1alpha1.IPRuleConfig:
+		o.Status.Nodes = nodes
+	case *apiv1alpha1.RoutingTable:
+		o.Status.Nodes = nodes
+	default:
+		Fail(fmt.Sprintf("%T has no node status", obj))
+	}
+	Expect(k8sClient.Status().Update(ctx, obj)).To(Succeed())
+}
+
+// deleteIfExists deletes obj unless it is gone already, e.g. because the reconcile under test
+// removed it.
+func deleteIfExists(ctx context.Context, obj client.Object) {
+	Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+}
@@ -20,17 +20,27 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net"
 	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -47,26 +57,52 @@ import (
 // IPRuleReconciler reconciles a IPRule object
 type IPRuleReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// DefaultTable and DefaultPriority replace an unset table/priority of IPRules stored before
+	// the defaulting webhook was in place (or with webhooks disabled).
+	DefaultTable    int
+	DefaultPriority int
+	// Resolver resolves ingress hostnames for the IngressHostname address source. If nil,
+	// net.DefaultResolver is used.
+	Resolver HostResolver
+
+	// hostAddrs caches the last successful lookup per ingress hostname, so a failing lookup
+	// keeps the rules of a hostname in place instead of withdrawing them.
+	hostAddrsMu sync.Mutex
+	hostAddrs   map[string][]netip.Addr
 }
 
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=iprules,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=iprules/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=iprules/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=routingtables,verbs=get;list;watch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;delete;patch
 // +kubebuilder:rbac:groups=apps,resources=daemonsets/finalizers,verbs=get;create;update;delete
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=ipruleconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=ipruleconfigs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=ipruleconfigs/finalizers,verbs=update
 
+// cleanupFinalizer keeps IPRules and Agents until the rules they caused are removed from the
+// nodes: deleting them drives the affected IPRuleConfigs through the absent state, and the
+// finalizer is only released once those configs are gone.
+const cleanupFinalizer = "iprule.operator.brtrm.dev/cleanup"
+
 // ipRuleEntry desired config candidate
 type ipRuleEntry struct {
 	IP        netip.Addr
 	Table     int
+	VRF       string
 	Priority  int
 	Owner     *apiv1alpha1.IPRule
 	PrefixLen int
+	Selector  apiv1alpha1.RuleSelector
+	Action    apiv1alpha1.RuleAction
+	Pool      string // agent pool as written into the IPRuleConfig, see configPool
+	Nodes     *metav1.LabelSelector
 }
 
 func (r *IPRuleReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) { // lint: reduce complexity by delegating
@@ -82,20 +118,47 @@ func (r *IPRuleReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	svcIPSet, err := r.collectServiceVIPs(ctx)
+	if err := r.ensureFinalizers(ctx, ipRules); err != nil {
+		metricReconcileErrors.WithLabelValues("iprule").Inc()
+		return ctrl.Result{}, err
+	}
+	deleting, err := r.deletingPools(ctx)
 	if err != nil {
 		metricReconcileErrors.WithLabelValues("iprule").Inc()
 		return ctrl.Result{}, err
 	}
 
-	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet)
+	resolveHostnames := usesHostnames(ipRules)
+	svcIPSet, err := r.collectServiceVIPs(ctx, resolveHostnames)
+	if err != nil {
+		metricReconcileErrors.WithLabelValues("iprule").Inc()
+		return ctrl.Result{}, err
+	}
+	tableIDs, err := r.collectTableIDs(ctx)
+	if err != nil {
+		metricReconcileErrors.WithLabelValues("iprule").Inc()
+		return ctrl.Result{}, err
+	}
+
+	entryMap := r.buildDesiredEntryMap(ipRules, svcIPSet, tableIDs)
+	// The rules of a pool whose Agent is being deleted are torn down
+	for key, e := range entryMap {
+		if deleting[e.Pool] {
+			delete(entryMap, key)
+		}
+	}
 	created, updated, unchanged, err := r.applyDesiredConfigs(ctx, entryMap)
+	r.updateRuleStatuses(ctx, ipRules, svcIPSet, tableIDs, entryMap, err)
 	if err != nil {
 		metricReconcileErrors.WithLabelValues("iprule").Inc()
 		return ctrl.Result{}, err
 	}
 
-	absentTotal, newlyAbsent := r.markAbsent(ctx, entryMap)
+	absentTotal, newlyAbsent := r.markAbsent(ctx, entryMap, deleting)
+	if err := r.releaseRules(ctx, ipRules); err != nil {
+		metricReconcileErrors.WithLabelValues("iprule").Inc()
+		return ctrl.Result{}, err
+	}
 
 	// Update metrics
 	metricDesiredGauge.Set(float64(len(entryMap)))
@@ -109,67 +172,462 @@ func (r *IPRuleReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.
 		"newlyAbsent", newlyAbsent,
 		"absentTotal", absentTotal,
 	)
+	if resolveHostnames {
+		return ctrl.Result{RequeueAfter: hostnameResyncPeriod}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
-func (r *IPRuleReconciler) collectServiceVIPs(ctx context.Context) (map[netip.Addr][]netip.Addr, error) {
+// serviceVIP is a service ClusterIP together with the service addresses of the same family, one
+// list per AddressSource, and the labels the IPRule selectors are evaluated against.
+type serviceVIP struct {
+	Namespace       string
+	Name            string
+	Labels          map[string]string
+	NamespaceLabels map[string]string
+	LBIPs           []netip.Addr // status.loadBalancer.ingress[].ip
+	HostnameIPs     []netip.Addr // status.loadBalancer.ingress[].hostname, resolved
+	RequestedIPs    []netip.Addr // spec.loadBalancerIP
+	ExternalIPs     []netip.Addr // spec.externalIPs
+}
+
+// addresses returns the addresses of v for one source. For ClusterIP that is the key itself.
+func (v serviceVIP) addresses(clusterIP netip.Addr, src apiv1alpha1.AddressSource) []netip.Addr {
+	switch src {
+	case apiv1alpha1.AddressSourceIngressIP:
+		return v.LBIPs
+	case apiv1alpha1.AddressSourceIngressHostname:
+		return v.HostnameIPs
+	case apiv1alpha1.AddressSourceLoadBalancerIP:
+		return v.RequestedIPs
+	case apiv1alpha1.AddressSourceExternalIP:
+		return v.ExternalIPs
+	case apiv1alpha1.AddressSourceClusterIP:
+		return []netip.Addr{clusterIP}
+	}
+	return nil
+}
+
+// HostResolver resolves LoadBalancer ingress hostnames; *net.Resolver satisfies it.
+type HostResolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// hostnameResyncPeriod bounds how long a DNS change of an ingress hostname goes unnoticed, as
+// there is no watch event for it.
+const hostnameResyncPeriod = time.Minute
+
+// hostnameLookupTimeout bounds all ingress hostname lookups of one reconcile together, and
+// hostnameLookupWorkers the lookups in flight at a time.
+const (
+	hostnameLookupTimeout = 5 * time.Second
+	hostnameLookupWorkers = 16
+)
+
+// collectServiceVIPs maps every service ClusterIP to the service addresses of the same IP family.
+// A dual-stack service therefore yields one entry per family, and so one IPRuleConfig each.
+// Ingress hostnames are only resolved when resolveHostnames is set, i.e. some IPRule uses them.
+func (r *IPRuleReconciler) collectServiceVIPs(ctx context.Context, resolveHostnames bool) (map[netip.Addr]serviceVIP, error) {
 	svcList := &corev1.ServiceList{}
 	if err := r.List(ctx, svcList, &client.ListOptions{}); err != nil {
 		return nil, err
 	}
-	svcIPSet := map[netip.Addr][]netip.Addr{}
-	for _, svc := range svcList.Items {
-		for _, ing := range svc.Status.LoadBalancer.Ingress {
-			if ing.IP == "" {
-				continue
+	nsList := &corev1.NamespaceList{}
+	if err := r.List(ctx, nsList); err != nil {
+		return nil, err
+	}
+	nsLabels := make(map[string]map[string]string, len(nsList.Items))
+	for i := range nsList.Items {
+		nsLabels[nsList.Items[i].Name] = nsList.Items[i].Labels
+	}
+	var hostAddrs map[string][]netip.Addr
+	if resolveHostnames {
+		hostAddrs = r.resolveHostnames(ctx, ingressHostnames(svcList))
+	}
+	svcIPSet := map[netip.Addr]serviceVIP{}
+	for i := range svcList.Items {
+		svc := &svcList.Items[i]
+		clusterIPs := serviceClusterIPs(svc)
+		for _, clusterIP := range clusterIPs {
+			svcIPSet[clusterIP] = serviceVIP{Namespace: svc.Namespace, Name: svc.Name, Labels: svc.Labels, NamespaceLabels: nsLabels[svc.Namespace]}
+		}
+		// add files an address under the ClusterIP of its family; addresses without one are dropped
+		add := func(field func(*serviceVIP) *[]netip.Addr, raw string) {
+			ip, err := netip.ParseAddr(raw)
+			if err != nil {
+				return
 			}
-			clusterIP, _ := netip.ParseAddr(svc.Spec.ClusterIP)
-			if !clusterIP.IsValid() {
-				continue
+			ip = ip.Unmap()
+			clusterIP, ok := clusterIPs[ip.Is4()]
+			if !ok {
+				return
 			}
-			svcVIP, _ := netip.ParseAddr(ing.IP)
-			if !svcVIP.IsValid() {
-				continue
+			v := svcIPSet[clusterIP]
+			*field(&v) = append(*field(&v), ip)
+			svcIPSet[clusterIP] = v
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			if ing.IP != "" {
+				add(func(v *serviceVIP) *[]netip.Addr { return &v.LBIPs }, ing.IP)
 			}
-			svcIPSet[clusterIP] = append(svcIPSet[clusterIP], svcVIP)
+			if ing.Hostname != "" && resolveHostnames {
+				for _, ip := range hostAddrs[ing.Hostname] {
+					add(func(v *serviceVIP) *[]netip.Addr { return &v.HostnameIPs }, ip.String())
+				}
+			}
+		}
+		if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && svc.Spec.LoadBalancerIP != "" {
+			add(func(v *serviceVIP) *[]netip.Addr { return &v.RequestedIPs }, svc.Spec.LoadBalancerIP)
+		}
+		for _, ext := range svc.Spec.ExternalIPs {
+			add(func(v *serviceVIP) *[]netip.Addr { return &v.ExternalIPs }, ext)
 		}
 	}
 	return svcIPSet, nil
 }
 
-func (r *IPRuleReconciler) buildDesiredEntryMap(ipRules *apiv1alpha1.IPRuleList, svcIPSet map[netip.Addr][]netip.Addr) map[string]ipRuleEntry {
+// ingressHostnames returns the distinct ingress hostnames of the services.
+func ingressHostnames(svcList *corev1.ServiceList) []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for i := range svcList.Items {
+		for _, ing := range svcList.Items[i].Status.LoadBalancer.Ingress {
+			if ing.Hostname != "" && !seen[ing.Hostname] {
+				seen[ing.Hostname] = true
+				hosts = append(hosts, ing.Hostname)
+			}
+		}
+	}
+	return hosts
+}
+
+// resolveHostnames looks up the ingress hostnames concurrently, all within hostnameLookupTimeout.
+// A failed lookup is logged and yields the addresses of the last successful one, so a DNS outage
+// does not withdraw the rules of a hostname; names without a successful lookup yet yield none.
+// Hostnames no longer in use are dropped from the cache.
+func (r *IPRuleReconciler) resolveHostnames(ctx context.Context, hosts []string) map[string][]netip.Addr {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	log := logf.FromContext(ctx)
+	ctx, cancel := context.WithTimeout(ctx, hostnameLookupTimeout)
+	defer cancel()
+
+	r.hostAddrsMu.Lock()
+	defer r.hostAddrsMu.Unlock()
+	resolved := make(map[string][]netip.Addr, len(hosts))
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, hostnameLookupWorkers)
+	)
+	for _, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			ips, err := resolver.LookupNetIP(ctx, "ip", host)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Error(err, "failed to resolve ingress hostname, keeping the last known addresses",
+					"hostname", host, "addresses", r.hostAddrs[host])
+				resolved[host] = r.hostAddrs[host]
+				return
+			}
+			resolved[host] = ips
+		}()
+	}
+	wg.Wait()
+
+	r.hostAddrs = map[string][]netip.Addr{}
+	for host, ips := range resolved {
+		if len(ips) > 0 {
+			r.hostAddrs[host] = ips
+		}
+	}
+	return resolved
+}
+
+// ruleAddressSources returns the address sources of an IPRule, defaulting to the ingress IPs.
+func ruleAddressSources(rule *apiv1alpha1.IPRule) []apiv1alpha1.AddressSource {
+	if len(rule.Spec.AddressSources) == 0 {
+		return []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceIngressIP}
+	}
+	return rule.Spec.AddressSources
+}
+
+// usesHostnames reports whether any IPRule matches resolved ingress hostnames.
+func usesHostnames(ipRules *apiv1alpha1.IPRuleList) bool {
+	for i := range ipRules.Items {
+		for _, src := range ruleAddressSources(&ipRules.Items[i]) {
+			if src == apiv1alpha1.AddressSourceIngressHostname {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesCIDR reports whether any address of the given sources lies within cidr. Contains is
+// false across families: v4 rules only match v4 addresses and vice versa.
+func (v serviceVIP) matchesCIDR(clusterIP netip.Addr, cidr netip.Prefix, sources []apiv1alpha1.AddressSource) bool {
+	for _, src := range sources {
+		for _, ip := range v.addresses(clusterIP, src) {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleSelectors converts the optional namespace and service selectors of an IPRule. An unset
+// selector selects everything.
+func ruleSelectors(rule *apiv1alpha1.IPRule) (namespaces, services labels.Selector, err error) {
+	namespaces, services = labels.Everything(), labels.Everything()
+	if rule.Spec.NamespaceSelector != nil {
+		if namespaces, err = metav1.LabelSelectorAsSelector(rule.Spec.NamespaceSelector); err != nil {
+			return nil, nil, fmt.Errorf("spec.namespaceSelector: %w", err)
+		}
+	}
+	if rule.Spec.ServiceSelector != nil {
+		if services, err = metav1.LabelSelectorAsSelector(rule.Spec.ServiceSelector); err != nil {
+			return nil, nil, fmt.Errorf("spec.serviceSelector: %w", err)
+		}
+	}
+	return namespaces, services, nil
+}
+
+// selectsService reports whether the service behind v passes both selectors.
+func selectsService(namespaces, services labels.Selector, v serviceVIP) bool {
+	return namespaces.Matches(labels.Set(v.NamespaceLabels)) && services.Matches(labels.Set(v.Labels))
+}
+
+// serviceClusterIPs returns the service's ClusterIP per family, keyed by Is4. Spec.ClusterIPs is
+// authoritative on dual-stack clusters; Spec.ClusterIP is only consulted for objects without it.
+func serviceClusterIPs(svc *corev1.Service) map[bool]netip.Addr {
+	ips := svc.Spec.ClusterIPs
+	if len(ips) == 0 && svc.Spec.ClusterIP != "" {
+		ips = []string{svc.Spec.ClusterIP}
+	}
+	out := make(map[bool]netip.Addr, len(ips))
+	for _, s := range ips {
+		ip, err := netip.ParseAddr(s) // also rejects "None" of headless services
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		if _, ok := out[ip.Is4()]; !ok {
+			out[ip.Is4()] = ip
+		}
+	}
+	return out
+}
+
+// collectTableIDs returns the registry of table names: the table number of every RoutingTable,
+// keyed by its name.
+func (r *IPRuleReconciler) collectTableIDs(ctx context.Context) (map[string]int, error) {
+	tables := &apiv1alpha1.RoutingTableList{}
+	if err := r.List(ctx, tables); err != nil {
+		return nil, fmt.Errorf("list RoutingTables: %w", err)
+	}
+	tableIDs := make(map[string]int, len(tables.Items))
+	for i := range tables.Items {
+		tableIDs[tables.Items[i].Name] = tables.Items[i].Spec.Table
+	}
+	return tableIDs, nil
+}
+
+// ruleTable returns the table number rule routes into: spec.table, or the table of the
+// RoutingTable named by spec.tableName. ok is false if no such RoutingTable exists. Rules routing
+// into a VRF have no table here; the agents resolve it per node.
+func (r *IPRuleReconciler) ruleTable(rule *apiv1alpha1.IPRule, tableIDs map[string]int) (table int, ok bool) {
+	if rule.Spec.VRF != "" {
+		return 0, true
+	}
+	if rule.Spec.TableName != "" {
+		table, ok = tableIDs[rule.Spec.TableName]
+		return table, ok
+	}
+	if rule.Spec.Table == 0 {
+		return r.DefaultTable, true
+	}
+	return rule.Spec.Table, true
+}
+
+func (r *IPRuleReconciler) buildDesiredEntryMap(
+	ipRules *apiv1alpha1.IPRuleList,
+	svcIPSet map[netip.Addr]serviceVIP,
+	tableIDs map[string]int,
+) map[string]ipRuleEntry {
 	entryMap := map[string]ipRuleEntry{}
-	for clusterIP, lbIPs := range svcIPSet {
-		for _, lbIP := range lbIPs {
-			for i := range ipRules.Items {
-				rule := &ipRules.Items[i]
-				cidr, _ := netip.ParsePrefix(rule.Spec.Cidr)
-				if !cidr.IsValid() || !cidr.Contains(lbIP) {
-					continue
-				}
-				entry := ipRuleEntry{IP: clusterIP, Table: rule.Spec.Table, Priority: rule.Spec.Priority, Owner: rule, PrefixLen: cidr.Bits()}
-				key := entry.IP.String() + "|" + strconv.Itoa(entry.Table) + "|" + strconv.Itoa(entry.Priority)
-				if existing, ok := entryMap[key]; ok {
-					if entry.PrefixLen > existing.PrefixLen { // most specific
-						entryMap[key] = entry
-					}
-				} else {
+	for clusterIP, v := range svcIPSet {
+		for i := range ipRules.Items {
+			rule := &ipRules.Items[i]
+			// Deleted rules keep existing until their configs were removed from the nodes
+			if !rule.DeletionTimestamp.IsZero() {
+				continue
+			}
+			cidr, _ := netip.ParsePrefix(rule.Spec.Cidr)
+			if !cidr.IsValid() || !v.matchesCIDR(clusterIP, cidr, ruleAddressSources(rule)) {
+				continue
+			}
+			// Invalid selectors are reported in the status and match nothing
+			namespaces, services, err := ruleSelectors(rule)
+			if err != nil || !selectsService(namespaces, services, v) {
+				continue
+			}
+			// Rules naming a table that is not registered are reported in the status and match nothing
+			table, ok := r.ruleTable(rule, tableIDs)
+			if !ok {
+				continue
+			}
+			priority := rule.Spec.Priority
+			if priority == 0 {
+				priority = r.DefaultPriority
+			}
+			entry := ipRuleEntry{IP: clusterIP, Table: table, VRF: rule.Spec.VRF, Priority: priority, Owner: rule,
+				PrefixLen: cidr.Bits(), Selector: rule.Spec.RuleSelector, Action: rule.Spec.RuleAction,
+				Pool: configPool(rule.Spec.AgentPool), Nodes: rule.Spec.NodeSelector}
+			key := entryKey(entry.Pool, entry.IP.String(), entry.Table, entry.VRF, entry.Priority, entry.Nodes, &entry.Selector, &entry.Action)
+			if existing, ok := entryMap[key]; ok {
+				if entry.PrefixLen > existing.PrefixLen { // most specific
 					entryMap[key] = entry
 				}
+			} else {
+				entryMap[key] = entry
 			}
 		}
 	}
 	return entryMap
 }
 
+// entryKey identifies a desired rule by agent pool, service IP, target table, priority, node
+// selector, traffic selector and action. Rules routing into a VRF are told apart by the VRF name,
+// as their table is only known on the nodes.
+func entryKey(pool, serviceIP string, table int, vrf string, priority int, nodes *metav1.LabelSelector,
+	sel *apiv1alpha1.RuleSelector, action *apiv1alpha1.RuleAction) string {
+	target := strconv.Itoa(table)
+	if vrf != "" {
+		target = "vrf=" + vrf
+	}
+	key := serviceIP + "|" + target + "|" + strconv.Itoa(priority)
+	if pool != "" {
+		key += "|pool=" + pool
+	}
+	if sel := selectorKey(nodes); sel != "" {
+		key += "|nodes=" + sel
+	}
+	if sel := ruleSelectorKey(sel); sel != "" {
+		key += "|" + sel
+	}
+	if a := ruleActionKey(action); a != "" {
+		key += "|" + a
+	}
+	return key
+}
+
+// ruleActionKey returns a canonical form of action, empty for a plain table lookup, which keeps
+// the keys and names of rules without an action as they were.
+func ruleActionKey(action *apiv1alpha1.RuleAction) string {
+	switch action.Action {
+	case "", apiv1alpha1.RuleActionLookup:
+		if action.SuppressPrefixLength != nil {
+			return "suppress_prefixlength=" + strconv.Itoa(int(*action.SuppressPrefixLength))
+		}
+		return ""
+	case apiv1alpha1.RuleActionGoto:
+		return "goto=" + strconv.FormatInt(action.GotoPriority, 10)
+	}
+	return "action=" + strings.ToLower(string(action.Action))
+}
+
+// ruleSelectorKey returns a canonical form of the traffic selector sel, empty if it matches all
+// traffic. Spellings the agents install as the same kernel rule yield the same key: a mark
+// without mask, a port range without end, an unmasked dst or the full uid range.
+func ruleSelectorKey(sel *apiv1alpha1.RuleSelector) string {
+	var parts []string
+	if sel.FwMark != nil {
+		mask := int64(math.MaxUint32)
+		if sel.FwMask != nil {
+			mask = *sel.FwMask
+		}
+		parts = append(parts, fmt.Sprintf("fwmark=%#x/%#x", *sel.FwMark, mask))
+	}
+	if sel.IIF != "" {
+		parts = append(parts, "iif="+sel.IIF)
+	}
+	if sel.OIF != "" {
+		parts = append(parts, "oif="+sel.OIF)
+	}
+	if sel.Dst != "" {
+		dst := sel.Dst
+		if p, err := netip.ParsePrefix(dst); err == nil {
+			dst = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked().String()
+		}
+		parts = append(parts, "to="+dst)
+	}
+	if sel.TOS != 0 {
+		parts = append(parts, "tos="+strconv.Itoa(int(sel.TOS)))
+	}
+	if sel.IPProto != "" {
+		parts = append(parts, "ipproto="+string(sel.IPProto))
+	}
+	portRange := func(name string, p *apiv1alpha1.PortRange) {
+		if p == nil {
+			return
+		}
+		end := p.End
+		if end == 0 {
+			end = p.Start
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d-%d", name, p.Start, end))
+	}
+	portRange("sport", sel.SrcPort)
+	portRange("dport", sel.DstPort)
+	if u := sel.UIDRange; u != nil && (u.Start != 0 || u.End != math.MaxUint32) {
+		parts = append(parts, fmt.Sprintf("uidrange=%d-%d", u.Start, u.End))
+	}
+	if sel.Invert {
+		parts = append(parts, "not")
+	}
+	return strings.Join(parts, "|")
+}
+
+// selectorKey returns a canonical form of sel, empty if it selects everything. Requirements are
+// sorted, so the same selector written in a different order yields the same key.
+func selectorKey(sel *metav1.LabelSelector) string {
+	if sel == nil {
+		return ""
+	}
+	s, err := metav1.LabelSelectorAsSelector(sel)
+	if err != nil {
+		// Rejected by the webhook; still keep it apart from valid selectors
+		data, _ := json.Marshal(sel)
+		return string(data)
+	}
+	return s.String()
+}
+
+// configPool returns the agentPool written into IPRuleConfigs for the pool of an IPRule or Agent:
+// empty for the default pool, so configs predating pools keep their name and spec.
+func configPool(pool string) string {
+	if pool == apiv1alpha1.DefaultAgentPool {
+		return ""
+	}
+	return pool
+}
+
 func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map[string]ipRuleEntry) (created, updated, unchanged int, err error) {
 	const (
 		labelManagedBy      = "managed-by"
 		labelManagedByValue = "ip-rule-operator"
 		annotationSpecHash  = "iprule.operator.brtrm.dev/spec-hash"
 	)
-	for _, e := range entryMap {
-		name := "iprc-" + strings.ReplaceAll(e.IP.String(), ".", "-")
+	for key, e := range entryMap {
+		name := configName(e.IP, e.Pool, key)
 		cfg := &apiv1alpha1.IPRuleConfig{}
 		errGet := r.Get(ctx, types.NamespacedName{Name: name}, cfg)
 		if k8serrors.IsNotFound(errGet) {
@@ -180,7 +638,17 @@ func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map
 		}
 		desiredState := apiv1alpha1.StatePresent
 		desiredHash := func() string {
-			data := fmt.Sprintf("table=%d|priority=%d|serviceIP=%s|state=%s", e.Table, e.Priority, e.IP.String(), desiredState)
+			selector, _ := json.Marshal(e.Selector)
+			action, _ := json.Marshal(e.Action)
+			data := fmt.Sprintf("table=%d|vrf=%s|priority=%d|serviceIP=%s|state=%s|selector=%s|action=%s",
+				e.Table, e.VRF, e.Priority, e.IP.String(), desiredState, selector, action)
+			if e.Pool != "" {
+				data += "|pool=" + e.Pool
+			}
+			if e.Nodes != nil {
+				nodes, _ := json.Marshal(e.Nodes)
+				data += "|nodeSelector=" + string(nodes)
+			}
 			sum := sha256.Sum256([]byte(data))
 			return hex.EncodeToString(sum[:])
 		}()
@@ -190,7 +658,21 @@ func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map
 			}
 			cfg.Labels[labelManagedBy] = labelManagedByValue
 			if e.Owner != nil {
+				// Another IPRule may have won the entry since; hand the config over, so the
+				// finalizer of a deleted former owner does not wait for it.
+				cfg.OwnerReferences = slices.DeleteFunc(cfg.OwnerReferences, func(ref metav1.OwnerReference) bool {
+					return ref.Kind == "IPRule" && ref.UID != e.Owner.UID
+				})
 				_ = controllerutil.SetControllerReference(e.Owner, cfg, r.Scheme)
+				// Carry the reserved-table opt-in over, otherwise the config webhook rejects the object
+				if v, ok := e.Owner.Annotations[apiv1alpha1.AnnotationAllowReservedTable]; ok {
+					if cfg.Annotations == nil {
+						cfg.Annotations = map[string]string{}
+					}
+					cfg.Annotations[apiv1alpha1.AnnotationAllowReservedTable] = v
+				} else {
+					delete(cfg.Annotations, apiv1alpha1.AnnotationAllowReservedTable)
+				}
 			}
 			if cfg.Annotations != nil {
 				if h, ok := cfg.Annotations[annotationSpecHash]; ok && h == desiredHash {
@@ -199,9 +681,14 @@ func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map
 				}
 			}
 			cfg.Spec.Table = e.Table
+			cfg.Spec.VRF = e.VRF
+			cfg.Spec.AgentPool = e.Pool
+			cfg.Spec.NodeSelector = e.Nodes.DeepCopy()
 			cfg.Spec.Priority = e.Priority
 			cfg.Spec.ServiceIP = e.IP.String()
 			cfg.Spec.State = desiredState
+			cfg.Spec.RuleSelector = e.Selector
+			cfg.Spec.RuleAction = e.Action
 			if cfg.Annotations == nil {
 				cfg.Annotations = map[string]string{}
 			}
@@ -216,6 +703,10 @@ func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map
 		case controllerutil.OperationResultCreated:
 			created++
 			metricConfigCreate.Inc()
+			r.Recorder.Eventf(cfg, corev1.EventTypeNormal, "Created", "Created for service IP %s", e.IP)
+			if e.Owner != nil {
+				r.Recorder.Eventf(e.Owner, corev1.EventTypeNormal, "ConfigCreated", "Created IPRuleConfig %s for service IP %s", cfg.Name, e.IP)
+			}
 		case controllerutil.OperationResultUpdated:
 			updated++
 			metricConfigUpdate.Inc()
@@ -224,7 +715,164 @@ func (r *IPRuleReconciler) applyDesiredConfigs(ctx context.Context, entryMap map
 	return created, updated, unchanged, nil
 }
 
-func (r *IPRuleReconciler) markAbsent(ctx context.Context, entryMap map[string]ipRuleEntry) (absentTotal, newlyAbsent int) {
+// configName returns the name of the IPRuleConfig generated for the entry with key (see entryKey)
+// of a service IP and agent pool. IPv6 addresses are written in their expanded form, so the name
+// is stable and never contains "--" from "::" (e.g. fd00::a becomes
+// iprc-fd00-0000-0000-0000-0000-0000-0000-000a-<hash>). The hash of the key tells apart the
+// entries of one address, e.g. nested CIDRs at different priorities, a table and a VRF, two
+// fwmarks or a lookup and a blackhole rule. Pools
+// other than the default one are appended after a dot, which the address part never contains
+// (e.g. iprc-10-0-0-1-<hash>.edge).
+func configName(ip netip.Addr, pool, key string) string {
+	var name string
+	if ip.Is4() {
+		name = "iprc-" + strings.ReplaceAll(ip.String(), ".", "-")
+	} else {
+		name = "iprc-" + strings.ReplaceAll(ip.StringExpanded(), ":", "-")
+	}
+	sum := sha256.Sum256([]byte(key))
+	name += "-" + hex.EncodeToString(sum[:4])
+	if pool != "" {
+		name += "." + pool
+	}
+	return name
+}
+
+// updateRuleStatuses writes the status of every IPRule. The status subresource is only patched
+// when something changed, so steady-state reconciles do not cause API writes.
+func (r *IPRuleReconciler) updateRuleStatuses(
+	ctx context.Context,
+	ipRules *apiv1alpha1.IPRuleList,
+	svcIPSet map[netip.Addr]serviceVIP,
+	tableIDs map[string]int,
+	entryMap map[string]ipRuleEntry,
+	applyErr error,
+) {
+	log := logf.FromContext(ctx)
+	for i := range ipRules.Items {
+		rule := &ipRules.Items[i]
+		orig := rule.DeepCopy()
+		_, tableFound := r.ruleTable(rule, tableIDs)
+		rule.Status = computeRuleStatus(rule, svcIPSet, tableFound, entryMap, applyErr)
+		if equality.Semantic.DeepEqual(orig.Status, rule.Status) {
+			continue
+		}
+		if err := r.Status().Patch(ctx, rule, client.MergeFrom(orig)); err != nil {
+			if !k8serrors.IsNotFound(err) {
+				log.Error(err, "failed to update IPRule status", "name", rule.Name)
+			}
+		}
+	}
+}
+
+// computeRuleStatus derives the status of a single IPRule from the current service set and the
+// desired IPRuleConfig entries. tableFound is false if spec.tableName names no RoutingTable.
+// Existing conditions are updated in place so LastTransitionTime
+// only moves when a condition actually flips.
+func computeRuleStatus(
+	rule *apiv1alpha1.IPRule,
+	svcIPSet map[netip.Addr]serviceVIP,
+	tableFound bool,
+	entryMap map[string]ipRuleEntry,
+	applyErr error,
+) apiv1alpha1.IPRuleStatus {
+	status := *rule.Status.DeepCopy()
+	status.ObservedGeneration = rule.Generation
+
+	cidr, err := netip.ParsePrefix(rule.Spec.Cidr)
+	if err != nil {
+		status.MatchedServices = 0
+		status.ConfigCount = 0
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(apiv1alpha1.IPRuleConditionInvalidCIDR),
+			Status:             metav1.ConditionTrue,
+			Reason:             "ParseFailed",
+			Message:            err.Error(),
+			ObservedGeneration: rule.Generation,
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(apiv1alpha1.IPRuleConditionReady),
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidCIDR",
+			Message:            fmt.Sprintf("spec.cidr %q is not a valid CIDR", rule.Spec.Cidr),
+			ObservedGeneration: rule.Generation,
+		})
+		return status
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               string(apiv1alpha1.IPRuleConditionInvalidCIDR),
+		Status:             metav1.ConditionFalse,
+		Reason:             "Valid",
+		Message:            "spec.cidr is valid",
+		ObservedGeneration: rule.Generation,
+	})
+
+	namespaces, services, err := ruleSelectors(rule)
+	if err != nil {
+		status.MatchedServices = 0
+		status.ConfigCount = 0
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(apiv1alpha1.IPRuleConditionReady),
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidSelector",
+			Message:            err.Error(),
+			ObservedGeneration: rule.Generation,
+		})
+		return status
+	}
+
+	if !tableFound {
+		status.MatchedServices = 0
+		status.ConfigCount = 0
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               string(apiv1alpha1.IPRuleConditionReady),
+			Status:             metav1.ConditionFalse,
+			Reason:             "TableNotFound",
+			Message:            fmt.Sprintf("no RoutingTable named %q", rule.Spec.TableName),
+			ObservedGeneration: rule.Generation,
+		})
+		return status
+	}
+
+	// A service counts once, no matter how many of its addresses fall into the CIDR. svcIPSet is
+	// keyed by ClusterIP, so a dual-stack service has an entry per family.
+	matched := map[types.NamespacedName]struct{}{}
+	sources := ruleAddressSources(rule)
+	for clusterIP, v := range svcIPSet {
+		if selectsService(namespaces, services, v) && v.matchesCIDR(clusterIP, cidr, sources) {
+			matched[types.NamespacedName{Namespace: v.Namespace, Name: v.Name}] = struct{}{}
+		}
+	}
+	// Only entries this rule won (most specific CIDR) produce a config owned by it.
+	configs := map[string]struct{}{}
+	for key, e := range entryMap {
+		if e.Owner != nil && e.Owner.Name == rule.Name {
+			configs[configName(e.IP, e.Pool, key)] = struct{}{}
+		}
+	}
+	status.MatchedServices = int32(len(matched))
+	status.ConfigCount = int32(len(configs))
+
+	ready := metav1.Condition{
+		Type:               string(apiv1alpha1.IPRuleConditionReady),
+		Status:             metav1.ConditionTrue,
+		Reason:             "Reconciled",
+		Message:            fmt.Sprintf("%d services matched, %d IPRuleConfigs generated", len(matched), len(configs)),
+		ObservedGeneration: rule.Generation,
+	}
+	if applyErr != nil {
+		ready.Status = metav1.ConditionFalse
+		ready.Reason = "ApplyFailed"
+		ready.Message = applyErr.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+	return status
+}
+
+// markAbsent marks the managed IPRuleConfigs absent that no entry desires anymore, in the pools
+// being torn down (their Agent is deleted) all of them. Configs created by hand are left alone: the
+// agents never apply them, so they would never acknowledge their removal either.
+func (r *IPRuleReconciler) markAbsent(ctx context.Context, entryMap map[string]ipRuleEntry, deleting map[string]bool) (absentTotal, newlyAbsent int) {
 	const (
 		labelManagedBy      = "managed-by"
 		labelManagedByValue = "ip-rule-operator"
@@ -237,11 +885,20 @@ func (r *IPRuleReconciler) markAbsent(ctx context.Context, entryMap map[string]i
 	}
 	for i := range existingCfgs.Items {
 		cfg := &existingCfgs.Items[i]
+		teardown := deleting[cfg.Spec.AgentPool]
 		if cfg.Labels[labelManagedBy] != labelManagedByValue {
 			continue
 		}
-		k := cfg.Spec.ServiceIP + "|" + strconv.Itoa(cfg.Spec.Table) + "|" + strconv.Itoa(cfg.Spec.Priority)
-		if _, ok := entryMap[k]; !ok {
+		// A config named differently than its entry predates the current naming; the entry gets a
+		// config of its own.
+		key := entryKey(cfg.Spec.AgentPool, cfg.Spec.ServiceIP, cfg.Spec.Table, cfg.Spec.VRF, cfg.Spec.Priority, cfg.Spec.NodeSelector,
+			&cfg.Spec.RuleSelector, &cfg.Spec.RuleAction)
+		e, desired := entryMap[key]
+		replacement := ""
+		if desired {
+			replacement = configName(e.IP, e.Pool, key)
+		}
+		if !desired || replacement != cfg.Name {
 			if cfg.Spec.State != apiv1alpha1.StateAbsent {
 				orig := cfg.DeepCopy()
 				cfg.Spec.State = apiv1alpha1.StateAbsent
@@ -253,6 +910,14 @@ func (r *IPRuleReconciler) markAbsent(ctx context.Context, entryMap map[string]i
 				} else {
 					newlyAbsent++
 					metricConfigMarkedAbsent.Inc()
+					reason := "no IPRule selects the service IP anymore"
+					switch {
+					case teardown:
+						reason = "the Agent of its pool is being deleted"
+					case desired:
+						reason = "replaced by IPRuleConfig " + replacement
+					}
+					r.Recorder.Eventf(cfg, corev1.EventTypeNormal, "MarkedAbsent", "Marked absent, %s; removing the rule from the nodes", reason)
 				}
 			}
 		}
@@ -263,13 +928,74 @@ func (r *IPRuleReconciler) markAbsent(ctx context.Context, entryMap map[string]i
 	return absentTotal, newlyAbsent
 }
 
+// ensureFinalizers adds the cleanup finalizer to every IPRule that is not being deleted.
+func (r *IPRuleReconciler) ensureFinalizers(ctx context.Context, ipRules *apiv1alpha1.IPRuleList) error {
+	for i := range ipRules.Items {
+		rule := &ipRules.Items[i]
+		if !rule.DeletionTimestamp.IsZero() || controllerutil.ContainsFinalizer(rule, cleanupFinalizer) {
+			continue
+		}
+		orig := rule.DeepCopy()
+		controllerutil.AddFinalizer(rule, cleanupFinalizer)
+		if err := r.Patch(ctx, rule, client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+	}
+	return nil
+}
+
+// releaseRules removes the cleanup finalizer from deleted IPRules that no longer control any
+// IPRuleConfig, i.e. whose configs were marked absent and deleted after the agents removed the rules.
+func (r *IPRuleReconciler) releaseRules(ctx context.Context, ipRules *apiv1alpha1.IPRuleList) error {
+	cfgs := &apiv1alpha1.IPRuleConfigList{}
+	if err := r.List(ctx, cfgs); err != nil {
+		return fmt.Errorf("list IPRuleConfigs: %w", err)
+	}
+	owners := map[types.UID]bool{}
+	for i := range cfgs.Items {
+		if ref := metav1.GetControllerOf(&cfgs.Items[i]); ref != nil {
+			owners[ref.UID] = true
+		}
+	}
+	for i := range ipRules.Items {
+		rule := &ipRules.Items[i]
+		if rule.DeletionTimestamp.IsZero() || owners[rule.UID] || !controllerutil.ContainsFinalizer(rule, cleanupFinalizer) {
+			continue
+		}
+		orig := rule.DeepCopy()
+		controllerutil.RemoveFinalizer(rule, cleanupFinalizer)
+		if err := r.Patch(ctx, rule, client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		logf.FromContext(ctx).Info("rules of deleted IPRule removed from all nodes, releasing it", "name", rule.Name)
+	}
+	return nil
+}
+
+// deletingPools returns the pools (see configPool) whose Agent is being deleted. Their
+// IPRuleConfigs are marked absent, so the agents remove the rules before their DaemonSet goes away.
+func (r *IPRuleReconciler) deletingPools(ctx context.Context) (map[string]bool, error) {
+	agents := &apiv1alpha1.AgentList{}
+	if err := r.List(ctx, agents); err != nil {
+		return nil, fmt.Errorf("list Agents: %w", err)
+	}
+	deleting := map[string]bool{}
+	for i := range agents.Items {
+		if !agents.Items[i].DeletionTimestamp.IsZero() {
+			deleting[configPool(agents.Items[i].Name)] = true
+		}
+	}
+	return deleting, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *IPRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	// Predicate: react only to Services of type LoadBalancer or when LB ingress IPs changed
+	// Predicate: react only to Services that carry addresses an IPRule can match, and only when
+	// those addresses or the labels changed
 	servicePred := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			if svc, ok := e.Object.(*corev1.Service); ok {
-				return svc.Spec.Type == corev1.ServiceTypeLoadBalancer
+				return len(serviceAddresses(svc)) > 0
 			}
 			return false
 		},
@@ -279,28 +1005,20 @@ func (r *IPRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			if !okOld || !okNew {
 				return false
 			}
-			if newSvc.Spec.Type != corev1.ServiceTypeLoadBalancer && oldSvc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			oldAddrs := serviceAddresses(oldSvc)
+			newAddrs := serviceAddresses(newSvc)
+			if len(oldAddrs) == 0 && len(newAddrs) == 0 {
 				return false
 			}
-			// Trigger on type change or when ingress IP list changed
-			if oldSvc.Spec.Type != newSvc.Spec.Type {
+			// Labels feed the serviceSelector of IPRules
+			if !equality.Semantic.DeepEqual(oldSvc.Labels, newSvc.Labels) {
 				return true
 			}
-			oldIPs := loadBalancerIPs(oldSvc)
-			newIPs := loadBalancerIPs(newSvc)
-			if len(oldIPs) != len(newIPs) {
-				return true
-			}
-			for i := range oldIPs {
-				if oldIPs[i] != newIPs[i] {
-					return true
-				}
-			}
-			return false
+			return !slices.Equal(oldAddrs, newAddrs)
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			if svc, ok := e.Object.(*corev1.Service); ok {
-				return svc.Spec.Type == corev1.ServiceTypeLoadBalancer
+				return len(serviceAddresses(svc)) > 0
 			}
 			return false
 		},
@@ -308,7 +1026,8 @@ func (r *IPRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&apiv1alpha1.IPRule{}).
+		// Status writes must not trigger another global reconcile
+		For(&apiv1alpha1.IPRule{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Watches(
 			&corev1.Service{},
 			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
@@ -317,18 +1036,75 @@ func (r *IPRuleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			}),
 			builder.WithPredicates(servicePred),
 		).
+		// Namespace labels feed the namespaceSelector of IPRules
+		Watches(
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{}}
+			}),
+			builder.WithPredicates(predicate.LabelChangedPredicate{}),
+		).
+		// RoutingTables resolve the spec.tableName of IPRules
+		Watches(
+			&apiv1alpha1.RoutingTable{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{}}
+			}),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		// A deleted IPRuleConfig may release the finalizer of a deleted IPRule
+		Watches(
+			&apiv1alpha1.IPRuleConfig{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{}}
+			}),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc:  func(event.CreateEvent) bool { return false },
+				UpdateFunc:  func(event.UpdateEvent) bool { return false },
+				DeleteFunc:  func(event.DeleteEvent) bool { return true },
+				GenericFunc: func(event.GenericEvent) bool { return false },
+			}),
+		).
+		// Deleting an Agent tears down the rules of its pool
+		Watches(
+			&apiv1alpha1.Agent{},
+			handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+				return []reconcile.Request{{}}
+			}),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
 		Named("ipRule").
 		Complete(r)
 }
 
-// loadBalancerIPs deterministically extracts LB IPs of a Service
-func loadBalancerIPs(svc *corev1.Service) []string {
-	ips := make([]string, 0, len(svc.Status.LoadBalancer.Ingress))
+// serviceAddresses deterministically lists every address of a Service an AddressSource can
+// refer to, prefixed with the source so a move between sources is a change as well. Headless
+// and ExternalName services have none.
+func serviceAddresses(svc *corev1.Service) []string {
+	clusterIPs := serviceClusterIPs(svc)
+	if len(clusterIPs) == 0 {
+		return nil
+	}
+	addrs := make([]string, 0, len(clusterIPs)+len(svc.Spec.ExternalIPs)+len(svc.Status.LoadBalancer.Ingress)+1)
+	for _, ip := range svc.Spec.ClusterIPs {
+		addrs = append(addrs, string(apiv1alpha1.AddressSourceClusterIP)+"="+ip)
+	}
+	if len(svc.Spec.ClusterIPs) == 0 {
+		addrs = append(addrs, string(apiv1alpha1.AddressSourceClusterIP)+"="+svc.Spec.ClusterIP)
+	}
+	for _, ip := range svc.Spec.ExternalIPs {
+		addrs = append(addrs, string(apiv1alpha1.AddressSourceExternalIP)+"="+ip)
+	}
+	if svc.Spec.Type == corev1.ServiceTypeLoadBalancer && svc.Spec.LoadBalancerIP != "" {
+		addrs = append(addrs, string(apiv1alpha1.AddressSourceLoadBalancerIP)+"="+svc.Spec.LoadBalancerIP)
+	}
 	for _, ing := range svc.Status.LoadBalancer.Ingress {
 		if ing.IP != "" {
-			ips = append(ips, ing.IP)
+			addrs = append(addrs, string(apiv1alpha1.AddressSourceIngressIP)+"="+ing.IP)
+		}
+		if ing.Hostname != "" {
+			addrs = append(addrs, string(apiv1alpha1.AddressSourceIngressHostname)+"="+ing.Hostname)
 		}
 	}
-	// Order is already as delivered by API server; optionally sort if required
-	return ips
+	return addrs
 }
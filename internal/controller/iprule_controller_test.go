@@ -24,6 +24,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -31,6 +33,15 @@ import (
 	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
 )
 
+// dropFinalizers removes all finalizers of obj, as no controller releases them in the tests.
+func dropFinalizers(ctx context.Context, obj client.Object) {
+	if len(obj.GetFinalizers()) == 0 {
+		return
+	}
+	obj.SetFinalizers(nil)
+	Expect(client.IgnoreNotFound(k8sClient.Update(ctx, obj))).To(Succeed())
+}
+
 var _ = Describe("IpRule Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-iprule"
@@ -64,6 +75,7 @@ var _ = Describe("IpRule Controller", func() {
 			resource := &apiv1alpha1.IPRule{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			if err == nil {
+				dropFinalizers(ctx, resource)
 				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
 			}
 
@@ -78,8 +90,9 @@ var _ = Describe("IpRule Controller", func() {
 		It("should successfully reconcile without services", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &IPRuleReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -164,7 +177,8 @@ var _ = Describe("IpRule Controller", func() {
 			rule := &apiv1alpha1.IPRule{}
 			err := k8sClient.Get(ctx, types.NamespacedName{Name: ruleName}, rule)
 			if err == nil {
-				Expect(k8sClient.Delete(ctx, rule)).To(Succeed())
+				dropFinalizers(ctx, rule)
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, rule))).To(Succeed())
 			}
 
 			By("Cleanup the service")
@@ -192,8 +206,9 @@ var _ = Describe("IpRule Controller", func() {
 
 			By("Reconciling the IPRule")
 			controllerReconciler := &IPRuleReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{})
@@ -213,5 +228,48 @@ var _ = Describe("IpRule Controller", func() {
 				return false
 			}, "10s", "500ms").Should(BeTrue())
 		})
+
+		It("should keep a deleted IPRule until its IPRuleConfig is gone", func() {
+			controllerReconciler := &IPRuleReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+
+			rule := &apiv1alpha1.IPRule{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ruleName}, rule)).To(Succeed())
+			Expect(rule.Finalizers).To(ContainElement(cleanupFinalizer))
+
+			By("Deleting the IPRule")
+			Expect(k8sClient.Delete(ctx, rule)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+
+			configList := &apiv1alpha1.IPRuleConfigList{}
+			Expect(k8sClient.List(ctx, configList)).To(Succeed())
+			var owned []apiv1alpha1.IPRuleConfig
+			for _, cfg := range configList.Items {
+				if ref := metav1.GetControllerOf(&cfg); ref != nil && ref.UID == rule.UID {
+					owned = append(owned, cfg)
+				}
+			}
+			Expect(owned).NotTo(BeEmpty())
+			for i := range owned {
+				Expect(owned[i].Spec.State).To(Equal(apiv1alpha1.StateAbsent))
+			}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: ruleName}, rule)).To(Succeed())
+
+			By("Deleting the absent IPRuleConfigs, as the IPRuleConfig controller does after the acks")
+			for i := range owned {
+				Expect(k8sClient.Delete(ctx, &owned[i])).To(Succeed())
+			}
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: ruleName}, rule)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
 	})
 })
@@ -0,0 +1,315 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// agentPodLabels select the pods of the agent DaemonSet (see AgentReconciler).
+var agentPodLabels = client.MatchingLabels{"app": "iprule-agent"}
+
+// IPRuleConfigReconciler aggregates the per-node status entries written by the agents
+// (status.nodes) into the appliedNodes/failedNodes counters of an IPRuleConfig. It also deletes
+// absent IPRuleConfigs once every node with a ready agent reported the rule as removed, so the
+// final delete happens exactly once instead of being raced by the agents.
+type IPRuleConfigReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+	// CleanupTimeout forces the deletion of an absent IPRuleConfig this long after it became
+	// absent, even if some nodes never reported Removed. Zero waits forever.
+	CleanupTimeout time.Duration
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=agents,verbs=get;list;watch
+
+func (r *IPRuleConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	timer := prometheus.NewTimer(metricReconcileDuration.WithLabelValues("ipruleconfig"))
+	defer timer.ObserveDuration()
+
+	metricReconcileTotal.WithLabelValues("ipruleconfig").Inc()
+
+	cfg := &apiv1alpha1.IPRuleConfig{}
+	if err := r.Get(ctx, req.NamespacedName, cfg); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	nodes, agentNodes, err := r.agentNodes(ctx, &cfg.Spec)
+	if err != nil {
+		metricReconcileErrors.WithLabelValues("ipruleconfig").Inc()
+		return ctrl.Result{}, err
+	}
+
+	orig := cfg.DeepCopy()
+	// Entries of nodes that left the cluster or the node selector would otherwise count (and block
+	// cleanup) forever.
+	cfg.Status.Nodes = slices.DeleteFunc(cfg.Status.Nodes, func(n apiv1alpha1.NodeRuleStatus) bool {
+		return !nodes[n.NodeName]
+	})
+	var result ctrl.Result
+	if cfg.Spec.State == apiv1alpha1.StateAbsent {
+		if cfg.Status.AbsentSince == nil {
+			now := metav1.Now()
+			cfg.Status.AbsentSince = &now
+		}
+		pending := pendingNodes(cfg, agentNodes)
+		if len(pending) == 0 || r.cleanupTimedOut(ctx, cfg, pending) {
+			if err := r.deleteAbsent(ctx, cfg); err != nil {
+				metricReconcileErrors.WithLabelValues("ipruleconfig").Inc()
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+		cfg.Status.PendingNodes = pending
+		if r.CleanupTimeout > 0 {
+			result.RequeueAfter = time.Until(cfg.Status.AbsentSince.Add(r.CleanupTimeout))
+		}
+	} else {
+		cfg.Status.AbsentSince = nil
+		cfg.Status.PendingNodes = nil
+	}
+	cfg.Status.AppliedNodes, cfg.Status.FailedNodes = countNodeStates(cfg.Status.Nodes)
+	if equality.Semantic.DeepEqual(orig.Status, cfg.Status) {
+		return result, nil
+	}
+	// The node entries belong to the agents (SSA). A merge patch only sends them when entries were
+	// dropped, and then replaces the whole list, so it must not overwrite concurrent agent writes.
+	patch := client.MergeFrom(orig)
+	if len(cfg.Status.Nodes) != len(orig.Status.Nodes) {
+		patch = client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})
+	}
+	if err := r.Status().Patch(ctx, cfg, patch); err != nil {
+		metricReconcileErrors.WithLabelValues("ipruleconfig").Inc()
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	logf.FromContext(ctx).V(1).Info("updated node status", "applied", cfg.Status.AppliedNodes,
+		"failed", cfg.Status.FailedNodes, "pending", len(cfg.Status.PendingNodes))
+	return result, nil
+}
+
+// cleanupTimedOut reports whether the cleanup timeout of the absent cfg expired. The nodes that
+// never reported are surfaced in an event, as the object is gone afterwards.
+func (r *IPRuleConfigReconciler) cleanupTimedOut(ctx context.Context, cfg *apiv1alpha1.IPRuleConfig, pending []string) bool {
+	if r.CleanupTimeout <= 0 || time.Since(cfg.Status.AbsentSince.Time) < r.CleanupTimeout {
+		return false
+	}
+	logf.FromContext(ctx).Info("absent IPRuleConfig cleanup timed out, forcing deletion", "pendingNodes", pending)
+	r.Recorder.Eventf(cfg, corev1.EventTypeWarning, "CleanupTimedOut",
+		"Deleting after %s without removal report from nodes: %s", r.CleanupTimeout, strings.Join(pending, ", "))
+	metricCleanupTimeouts.Inc()
+	return true
+}
+
+// deleteAbsent deletes an absent IPRuleConfig whose cleanup completed.
+func (r *IPRuleConfigReconciler) deleteAbsent(ctx context.Context, cfg *apiv1alpha1.IPRuleConfig) error {
+	// The precondition keeps us from deleting a config the IPRule controller just revived.
+	rv := cfg.ResourceVersion
+	if err := r.Delete(ctx, cfg, client.Preconditions{ResourceVersion: &rv}); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil
+		}
+		return fmt.Errorf("delete IPRuleConfig: %w", err)
+	}
+	metricConfigDeleted.Inc()
+	logf.FromContext(ctx).Info("deleted absent IPRuleConfig after all nodes removed the rule")
+	return nil
+}
+
+// agentNodes returns the nodes selected by spec.nodeSelector and, sorted, the names of those
+// running a ready agent pod of spec.agentPool (see configPool). Nodes the DaemonSet does not place
+// a pod on (nodeSelector, taints) or whose agent is not ready cannot remove their rule now; their
+// agent garbage-collects it when it starts. Agents in audit mode never remove rules and are left
+// out as well.
+func (r *IPRuleConfigReconciler) agentNodes(ctx context.Context, spec *apiv1alpha1.IPRuleConfigSpec) (map[string]bool, []string, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.List(ctx, nodeList); err != nil {
+		return nil, nil, fmt.Errorf("list nodes: %w", err)
+	}
+	agents := &apiv1alpha1.AgentList{}
+	if err := r.List(ctx, agents); err != nil {
+		return nil, nil, fmt.Errorf("list agents: %w", err)
+	}
+	targets, err := configNodeSelector(spec)
+	if err != nil {
+		// Rejected by the webhook; an invalid selector targets no node
+		logf.FromContext(ctx).Error(err, "invalid nodeSelector")
+		targets = labels.Nothing()
+	}
+	nodes := make(map[string]bool, len(nodeList.Items))
+	// placed holds the nodes the DaemonSet keeps an agent on, evaluated with the same placement
+	// the DaemonSet is rendered from, so a pod about to be evicted is no ack target.
+	placed := make(map[string]bool, len(nodeList.Items))
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if !targets.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		nodes[node.Name] = true
+		for j := range agents.Items {
+			if configPool(agents.Items[j].Name) != spec.AgentPool {
+				continue
+			}
+			ok, err := placesAgent(&agents.Items[j], node)
+			if err != nil {
+				logf.FromContext(ctx).Error(err, "skipping agent with invalid placement", "agent", agents.Items[j].Name)
+				continue
+			}
+			if ok {
+				placed[node.Name] = true
+				break
+			}
+		}
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, agentPodLabels); err != nil {
+		return nil, nil, fmt.Errorf("list agent pods: %w", err)
+	}
+	var ready []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// Pods without the pool label predate pools and belong to the default pool
+		if pod.Labels[agentModeLabel] == string(apiv1alpha1.AgentModeAudit) || configPool(pod.Labels[agentPoolLabel]) != spec.AgentPool {
+			continue
+		}
+		if placed[pod.Spec.NodeName] && podReady(pod) && !slices.Contains(ready, pod.Spec.NodeName) {
+			ready = append(ready, pod.Spec.NodeName)
+		}
+	}
+	slices.Sort(ready)
+	return nodes, ready, nil
+}
+
+// podReady reports whether pod is running, ready and not terminating.
+func podReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// pendingNodes returns the nodes that have not yet reported the rule of the absent cfg as
+// Removed. Entries for an older generation do not count, they predate the removal.
+func pendingNodes(cfg *apiv1alpha1.IPRuleConfig, nodes []string) []string {
+	removed := make(map[string]bool, len(cfg.Status.Nodes))
+	for _, n := range cfg.Status.Nodes {
+		if n.State == apiv1alpha1.NodeStateRemoved && n.ObservedGeneration >= cfg.Generation {
+			removed[n.NodeName] = true
+		}
+	}
+	var pending []string
+	for _, name := range nodes {
+		if !removed[name] {
+			pending = append(pending, name)
+		}
+	}
+	return pending
+}
+
+// countNodeStates returns the number of Applied and Failed node entries.
+func countNodeStates(nodes []apiv1alpha1.NodeRuleStatus) (applied, failed int32) {
+	for _, n := range nodes {
+		switch n.State {
+		case apiv1alpha1.NodeStateApplied:
+			applied++
+		case apiv1alpha1.NodeStateFailed:
+			failed++
+		}
+	}
+	return applied, failed
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IPRuleConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Agent pods becoming (un)ready and changes to the agent placement (Agent spec, node labels
+	// and taints) change the set of nodes an absent config waits for; a deleted or relabelled
+	// node leaves entries behind in every config or in those no longer selecting it.
+	enqueueAbsent := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, _ client.Object) []reconcile.Request {
+		return r.configRequests(ctx, true)
+	})
+	enqueueAll := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, _ client.Object) []reconcile.Request {
+		return r.configRequests(ctx, false)
+	})
+	agentPods := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetLabels()["app"] == agentPodLabels["app"]
+	})
+	nodeChanged := predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, okOld := e.ObjectOld.(*corev1.Node)
+			newNode, okNew := e.ObjectNew.(*corev1.Node)
+			if !okOld || !okNew {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!equality.Semantic.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints)
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return true },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiv1alpha1.IPRuleConfig{}).
+		Watches(&corev1.Pod{}, enqueueAbsent, builder.WithPredicates(agentPods)).
+		Watches(&corev1.Node{}, enqueueAll, builder.WithPredicates(nodeChanged)).
+		Watches(&apiv1alpha1.Agent{}, enqueueAbsent, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named("ipruleconfig").
+		Complete(r)
+}
+
+// configRequests lists the IPRuleConfigs to reconcile, only the absent ones if absentOnly.
+func (r *IPRuleConfigReconciler) configRequests(ctx context.Context, absentOnly bool) []reconcile.Request {
+	list := &apiv1alpha1.IPRuleConfigList{}
+	if err := r.List(ctx, list); err != nil {
+		logf.FromContext(ctx).Error(err, "failed listing IPRuleConfigs")
+		return nil
+	}
+	var reqs []reconcile.Request
+	for i := range list.Items {
+		if absentOnly && list.Items[i].Spec.State != apiv1alpha1.StateAbsent {
+			continue
+		}
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+	}
+	return reqs
+}
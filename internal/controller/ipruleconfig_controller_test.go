@@ -0,0 +1,192 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+var _ = Describe("IPRuleConfig Controller", func() {
+	Context("When agents report node status", func() {
+		const resourceName = "iprc-10-0-0-50"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name: resourceName,
+		}
+
+		BeforeEach(func() {
+			ensureNode(ctx, "node-a")
+			ensureNode(ctx, "node-b")
+
+			By("creating the IPRuleConfig with two node entries and one of a departed node")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: resourceName,
+				},
+				Spec: apiv1alpha1.IPRuleConfigSpec{
+					ServiceIP: "10.0.0.50",
+					Table:     100,
+					Priority:  1000,
+					State:     apiv1alpha1.StatePresent,
+				},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+			setNodeStatus(ctx, cfg, []apiv1alpha1.NodeRuleStatus{
+				{NodeName: "node-a", State: apiv1alpha1.NodeStateApplied},
+				{NodeName: "node-b", State: apiv1alpha1.NodeStateFailed, LastError: "table missing"},
+				{NodeName: "node-gone", State: apiv1alpha1.NodeStateApplied},
+			})
+		})
+
+		AfterEach(func() {
+			By("Cleanup the IPRuleConfig")
+			deleteIfExists(ctx, &apiv1alpha1.IPRuleConfig{ObjectMeta: metav1.ObjectMeta{Name: resourceName}})
+		})
+
+		It("should aggregate applied and failed nodes and drop departed nodes", func() {
+			controllerReconciler := &IPRuleConfigReconciler{Client: k8sClient}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg := &apiv1alpha1.IPRuleConfig{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cfg)).To(Succeed())
+			Expect(cfg.Status.AppliedNodes).To(Equal(int32(1)))
+			Expect(cfg.Status.FailedNodes).To(Equal(int32(1)))
+			Expect(cfg.Status.Nodes).To(HaveLen(2))
+		})
+	})
+
+	Context("When an absent IPRuleConfig was removed from all nodes", func() {
+		const resourceName = "iprc-10-0-0-51"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name: resourceName,
+		}
+
+		BeforeEach(func() {
+			By("creating the absent IPRuleConfig with a Removed node entry")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: resourceName,
+				},
+				Spec: apiv1alpha1.IPRuleConfigSpec{
+					ServiceIP: "10.0.0.51",
+					Table:     100,
+					Priority:  1000,
+					State:     apiv1alpha1.StateAbsent,
+				},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+			setNodeStatus(ctx, cfg, []apiv1alpha1.NodeRuleStatus{
+				{NodeName: "node-a", State: apiv1alpha1.NodeStateRemoved, ObservedGeneration: cfg.Generation},
+			})
+		})
+
+		It("should delete the IPRuleConfig", func() {
+			controllerReconciler := &IPRuleConfigReconciler{Client: k8sClient}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg := &apiv1alpha1.IPRuleConfig{}
+			err = k8sClient.Get(ctx, typeNamespacedName, cfg)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Context("When an absent IPRuleConfig waits for a node with a ready agent", func() {
+		const resourceName = "iprc-10-0-0-52"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name: resourceName,
+		}
+
+		BeforeEach(func() {
+			ensureNode(ctx, "node-a")
+			ensureReadyAgentPod(ctx, "node-a")
+
+			By("creating the absent IPRuleConfig without node entries")
+			cfg := &apiv1alpha1.IPRuleConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: resourceName,
+				},
+				Spec: apiv1alpha1.IPRuleConfigSpec{
+					ServiceIP: "10.0.0.52",
+					Table:     100,
+					Priority:  1000,
+					State:     apiv1alpha1.StateAbsent,
+				},
+			}
+			Expect(k8sClient.Create(ctx, cfg)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			deleteIfExists(ctx, &apiv1alpha1.IPRuleConfig{ObjectMeta: metav1.ObjectMeta{Name: resourceName}})
+		})
+
+		It("should keep the IPRuleConfig and list the pending node", func() {
+			controllerReconciler := &IPRuleConfigReconciler{Client: k8sClient}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg := &apiv1alpha1.IPRuleConfig{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cfg)).To(Succeed())
+			Expect(cfg.Status.PendingNodes).To(Equal([]string{"node-a"}))
+			Expect(cfg.Status.AbsentSince).NotTo(BeNil())
+		})
+
+		It("should force the deletion after the cleanup timeout", func() {
+			recorder := record.NewFakeRecorder(10)
+			controllerReconciler := &IPRuleConfigReconciler{
+				Client:         k8sClient,
+				Recorder:       recorder,
+				CleanupTimeout: time.Millisecond,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			time.Sleep(10 * time.Millisecond)
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg := &apiv1alpha1.IPRuleConfig{}
+			err = k8sClient.Get(ctx, typeNamespacedName, cfg)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(recorder.Events).To(Receive(ContainSubstring("CleanupTimedOut")))
+		})
+	})
+})
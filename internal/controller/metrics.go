@@ -48,6 +48,16 @@ var (
 		Help: "Total number of IPRuleConfig resources marked as absent",
 	})
 
+	metricConfigDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_operator_config_deletes_total",
+		Help: "Total number of absent IPRuleConfig resources deleted after all nodes removed the rule",
+	})
+
+	metricCleanupTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iprule_operator_cleanup_timeouts_total",
+		Help: "Total number of absent IPRuleConfig resources deleted after the cleanup timeout with nodes still pending",
+	})
+
 	metricReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "iprule_operator_reconcile_total",
 		Help: "Total number of reconciliation runs",
@@ -65,20 +75,20 @@ var (
 	}, []string{"controller"})
 
 	// Agent Controller Metrics
-	metricAgentDaemonSetDesired = prometheus.NewGauge(prometheus.GaugeOpts{
+	metricAgentDaemonSetDesired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "iprule_operator_agent_daemonset_desired",
-		Help: "Desired number of agent pods",
-	})
+		Help: "Desired number of agent pods per agent pool",
+	}, []string{"pool"})
 
-	metricAgentDaemonSetCurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+	metricAgentDaemonSetCurrent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "iprule_operator_agent_daemonset_current",
-		Help: "Current number of agent pods scheduled",
-	})
+		Help: "Current number of agent pods scheduled per agent pool",
+	}, []string{"pool"})
 
-	metricAgentDaemonSetReady = prometheus.NewGauge(prometheus.GaugeOpts{
+	metricAgentDaemonSetReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "iprule_operator_agent_daemonset_ready",
-		Help: "Number of ready agent pods",
-	})
+		Help: "Number of ready agent pods per agent pool",
+	}, []string{"pool"})
 
 	metricAgentDaemonSetOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "iprule_operator_agent_daemonset_operations_total",
@@ -94,6 +104,8 @@ func init() {
 		metricConfigCreate,
 		metricConfigUpdate,
 		metricConfigMarkedAbsent,
+		metricConfigDeleted,
+		metricCleanupTimeouts,
 		metricReconcileTotal,
 		metricReconcileErrors,
 		metricReconcileDuration,
@@ -0,0 +1,220 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// The placement of the agent pods is computed here once, for the DaemonSet (agentAffinity) and
+// for the absent-config cleanup (placesAgent), so the nodes that must acknowledge a removal are
+// exactly the nodes the DaemonSet runs on.
+
+// daemonSetTolerations are the NoExecute tolerations the DaemonSet controller adds to every pod
+// it creates, so an agent keeps running on a node that turns not-ready or unreachable.
+var daemonSetTolerations = []corev1.Toleration{
+	{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	{Key: corev1.TaintNodeUnreachable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+}
+
+// nodeSelectorOperators maps the node selector operators onto label selector operators.
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// agentAffinity returns the affinity of the agent pods: spec.affinity with the requirements of
+// spec.nodeLabelSelector added to every required node selector term.
+func agentAffinity(a *apiv1alpha1.Agent) (*corev1.Affinity, error) {
+	reqs, err := labelSelectorRequirements(a.Spec.NodeLabelSelector)
+	if err != nil || len(reqs) == 0 {
+		return a.Spec.Affinity, err
+	}
+	affinity := &corev1.Affinity{}
+	if a.Spec.Affinity != nil {
+		affinity = a.Spec.Affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: reqs}},
+		}
+		return affinity, nil
+	}
+	// Terms are ORed, their expressions ANDed: the selector has to hold in each of them.
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		term.MatchExpressions = append(term.MatchExpressions, reqs...)
+	}
+	return affinity, nil
+}
+
+// labelSelectorRequirements translates a label selector into node selector requirements.
+func labelSelectorRequirements(sel *metav1.LabelSelector) ([]corev1.NodeSelectorRequirement, error) {
+	if sel == nil {
+		return nil, nil
+	}
+	if _, err := metav1.LabelSelectorAsSelector(sel); err != nil {
+		return nil, fmt.Errorf("invalid nodeLabelSelector: %w", err)
+	}
+	keys := make([]string, 0, len(sel.MatchLabels))
+	for k := range sel.MatchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	reqs := make([]corev1.NodeSelectorRequirement, 0, len(keys)+len(sel.MatchExpressions))
+	for _, k := range keys {
+		reqs = append(reqs, corev1.NodeSelectorRequirement{
+			Key: k, Operator: corev1.NodeSelectorOpIn, Values: []string{sel.MatchLabels[k]},
+		})
+	}
+	for _, e := range sel.MatchExpressions {
+		reqs = append(reqs, corev1.NodeSelectorRequirement{
+			Key: e.Key, Operator: corev1.NodeSelectorOperator(e.Operator), Values: e.Values,
+		})
+	}
+	return reqs, nil
+}
+
+// placesAgent reports whether the DaemonSet of a keeps an agent pod on node. Like the DaemonSet
+// controller it evaluates spec.nodeSelector, the required node affinity and the NoExecute taints
+// against the tolerations of the pods. NoSchedule taints only keep new pods off a node; they do
+// not evict a running agent, which therefore still has to acknowledge removals.
+func placesAgent(a *apiv1alpha1.Agent, node *corev1.Node) (bool, error) {
+	if !labels.SelectorFromSet(a.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false, nil
+	}
+	affinity, err := agentAffinity(a)
+	if err != nil {
+		return false, err
+	}
+	if affinity != nil && affinity.NodeAffinity != nil && affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		ok, err := matchesNodeSelectorTerms(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms, node)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	tolerations := append(slices.Clone(a.Spec.Tolerations), daemonSetTolerations...)
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		if !slices.ContainsFunc(tolerations, func(t corev1.Toleration) bool { return t.ToleratesTaint(taint) }) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// configNodeSelector returns the selector of the nodes an IPRuleConfig targets: every node if
+// spec.nodeSelector is unset.
+func configNodeSelector(spec *apiv1alpha1.IPRuleConfigSpec) (labels.Selector, error) {
+	if spec.NodeSelector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(spec.NodeSelector)
+}
+
+// matchesNodeSelectorTerms reports whether node matches any of the terms. A term without
+// requirements matches no node.
+func matchesNodeSelectorTerms(terms []corev1.NodeSelectorTerm, node *corev1.Node) (bool, error) {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		labelSel, err := nodeSelectorAsSelector(term.MatchExpressions)
+		if err != nil {
+			return false, err
+		}
+		// metadata.name is the only field a node selector term supports
+		fieldSel, err := nodeSelectorAsSelector(term.MatchFields)
+		if err != nil {
+			return false, err
+		}
+		if labelSel.Matches(labels.Set(node.Labels)) && fieldSel.Matches(labels.Set{"metadata.name": node.Name}) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nodeSelectorAsSelector converts node selector requirements into a label selector.
+func nodeSelectorAsSelector(reqs []corev1.NodeSelectorRequirement) (labels.Selector, error) {
+	sel := labels.NewSelector()
+	for _, r := range reqs {
+		op, ok := nodeSelectorOperators[r.Operator]
+		if !ok {
+			return nil, fmt.Errorf("unsupported node selector operator %q", r.Operator)
+		}
+		req, err := labels.NewRequirement(r.Key, op, r.Values)
+		if err != nil {
+			return nil, err
+		}
+		sel = sel.Add(*req)
+	}
+	return sel, nil
+}
+
+// overlappingAgent describes the first of agents, other than a, whose DaemonSet also places an
+// agent on a node a places one on, or that forms the same pool from another namespace. Two agents
+// on one node compete for the host ports and delete each other's rules. It returns an empty string
+// if there is none.
+func overlappingAgent(a *apiv1alpha1.Agent, agents []apiv1alpha1.Agent, nodes []corev1.Node) (string, error) {
+	var placed []*corev1.Node
+	for i := range nodes {
+		ok, err := placesAgent(a, &nodes[i])
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			placed = append(placed, &nodes[i])
+		}
+	}
+	for i := range agents {
+		other := &agents[i]
+		if other.Name == a.Name {
+			if other.Namespace != a.Namespace {
+				return fmt.Sprintf("Agent %s/%s forms the same pool", other.Namespace, other.Name), nil
+			}
+			continue
+		}
+		for _, node := range placed {
+			// An invalid placement of the other Agent is reported on that Agent
+			if ok, err := placesAgent(other, node); err == nil && ok {
+				return fmt.Sprintf("the agents of Agent %s/%s run on node %s as well", other.Namespace, other.Name, node.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=routingtables,verbs=get;list;watch
+// +kubebuilder:rbac:groups=api.operator.brtrm.dev,resources=routingtables/status,verbs=get;update;patch
+
+// RoutingTableReconciler aggregates the per-node status entries written by the agents
+// (status.nodes) into the appliedNodes/failedNodes counters of a RoutingTable. The routes
+// themselves are installed by the agents.
+type RoutingTableReconciler struct {
+	client.Client
+}
+
+func (r *RoutingTableReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	timer := prometheus.NewTimer(metricReconcileDuration.WithLabelValues("routingtable"))
+	defer timer.ObserveDuration()
+
+	metricReconcileTotal.WithLabelValues("routingtable").Inc()
+
+	rt := &apiv1alpha1.RoutingTable{}
+	if err := r.Get(ctx, req.NamespacedName, rt); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	applied, failed := countNodeStates(rt.Status.Nodes)
+	if rt.Status.AppliedNodes == applied && rt.Status.FailedNodes == failed {
+		return ctrl.Result{}, nil
+	}
+	// Merge patch touches only the counters; the node entries belong to the agents (SSA).
+	patch := client.MergeFrom(rt.DeepCopy())
+	rt.Status.AppliedNodes = applied
+	rt.Status.FailedNodes = failed
+	if err := r.Status().Patch(ctx, rt, patch); err != nil {
+		metricReconcileErrors.WithLabelValues("routingtable").Inc()
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	logf.FromContext(ctx).V(1).Info("updated node counters", "applied", applied, "failed", failed)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RoutingTableReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiv1alpha1.RoutingTable{}).
+		Named("routingtable").
+		Complete(r)
+}
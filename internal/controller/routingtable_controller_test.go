@@ -0,0 +1,78 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+var _ = Describe("RoutingTable Controller", func() {
+	Context("When agents report node status", func() {
+		const resourceName = "uplink-a"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name: resourceName,
+		}
+
+		BeforeEach(func() {
+			By("creating the RoutingTable with two node entries")
+			rt := &apiv1alpha1.RoutingTable{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: resourceName,
+				},
+				Spec: apiv1alpha1.RoutingTableSpec{
+					Table:  100,
+					Routes: []apiv1alpha1.Route{{Destination: "0.0.0.0/0", Gateway: "192.168.100.1"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, rt)).To(Succeed())
+			setNodeStatus(ctx, rt, []apiv1alpha1.NodeRuleStatus{
+				{NodeName: "node-a", State: apiv1alpha1.NodeStateApplied},
+				{NodeName: "node-b", State: apiv1alpha1.NodeStateFailed, LastError: "network is unreachable"},
+			})
+		})
+
+		AfterEach(func() {
+			By("Cleanup the RoutingTable")
+			deleteIfExists(ctx, &apiv1alpha1.RoutingTable{ObjectMeta: metav1.ObjectMeta{Name: resourceName}})
+		})
+
+		It("should aggregate applied and failed nodes", func() {
+			controllerReconciler := &RoutingTableReconciler{Client: k8sClient}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			rt := &apiv1alpha1.RoutingTable{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, rt)).To(Succeed())
+			Expect(rt.Status.AppliedNodes).To(Equal(int32(1)))
+			Expect(rt.Status.FailedNodes).To(Equal(int32(1)))
+			Expect(rt.Status.Nodes).To(HaveLen(2))
+		})
+	})
+})
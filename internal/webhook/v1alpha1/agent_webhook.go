@@ -0,0 +1,173 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var agentLog = logf.Log.WithName("agent-resource")
+
+// SetupAgentWebhookWithManager registers the webhook for Agent in the manager.
+func SetupAgentWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&apiv1alpha1.Agent{}).
+		WithValidator(&AgentCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-api-operator-brtrm-dev-v1alpha1-agent,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.operator.brtrm.dev,resources=agents,verbs=create;update,versions=v1alpha1,name=vagent-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// AgentCustomValidator validates the Agent spec before it is rendered into the DaemonSet, so a
+// broken selector or toleration is rejected instead of producing an unschedulable DaemonSet. It
+// needs a client to keep the pool names unique across namespaces.
+type AgentCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &AgentCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type Agent.
+func (v *AgentCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	agent, ok := obj.(*apiv1alpha1.Agent)
+	if !ok {
+		return nil, fmt.Errorf("expected a Agent object but got %T", obj)
+	}
+	agentLog.V(1).Info("Validation for Agent upon creation", "name", agent.GetName())
+
+	if err := validateAgent(agent); err != nil {
+		return nil, err
+	}
+	// The name cannot change, so it is only checked on create
+	return nil, v.validateUniquePool(ctx, agent)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type Agent.
+func (v *AgentCustomValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	agent, ok := newObj.(*apiv1alpha1.Agent)
+	if !ok {
+		return nil, fmt.Errorf("expected a Agent object for the newObj but got %T", newObj)
+	}
+	agentLog.V(1).Info("Validation for Agent upon update", "name", agent.GetName())
+
+	if !agent.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, validateAgent(agent)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type Agent.
+func (v *AgentCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateAgent(agent *apiv1alpha1.Agent) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// The name is the pool the IPRules refer to and the value of the pool label of the agent pods
+	for _, msg := range validation.IsValidLabelValue(agent.Name) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "name"), agent.Name, msg))
+	}
+	allErrs = append(allErrs, metav1validation.ValidateLabels(agent.Spec.NodeSelector, specPath.Child("nodeSelector"))...)
+	if sel := agent.Spec.NodeLabelSelector; sel != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(sel,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("nodeLabelSelector"))...)
+	}
+	for i, t := range agent.Spec.Tolerations {
+		allErrs = append(allErrs, validateToleration(specPath.Child("tolerations").Index(i), t)...)
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(apiv1alpha1.GroupVersion.WithKind("Agent").GroupKind(), agent.Name, allErrs)
+}
+
+// validateUniquePool rejects an Agent named like one in another namespace. The name is the agent
+// pool, and the agents of two Agents of the same pool would delete each other's rules.
+func (v *AgentCustomValidator) validateUniquePool(ctx context.Context, agent *apiv1alpha1.Agent) error {
+	list := &apiv1alpha1.AgentList{}
+	if err := v.Client.List(ctx, list); err != nil {
+		return fmt.Errorf("list Agents: %w", err)
+	}
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Name != agent.Name || other.Namespace == agent.Namespace {
+			continue
+		}
+		return apierrors.NewInvalid(apiv1alpha1.GroupVersion.WithKind("Agent").GroupKind(), agent.Name, field.ErrorList{
+			field.Invalid(field.NewPath("metadata", "name"), agent.Name,
+				fmt.Sprintf("an Agent of this name exists in namespace %q; the name is the agent pool and must be unique in the cluster", other.Namespace)),
+		})
+	}
+	return nil
+}
+
+// validateToleration mirrors the API server's toleration validation for pods, which would
+// otherwise only surface as a failed DaemonSet update in the operator logs.
+func validateToleration(path *field.Path, t corev1.Toleration) field.ErrorList {
+	var errs field.ErrorList
+	if t.Key != "" {
+		for _, msg := range validation.IsQualifiedName(t.Key) {
+			errs = append(errs, field.Invalid(path.Child("key"), t.Key, msg))
+		}
+	}
+	switch t.Operator {
+	case corev1.TolerationOpEqual, "":
+		if t.Key == "" {
+			errs = append(errs, field.Invalid(path.Child("operator"), t.Operator,
+				"operator must be Exists when key is empty"))
+		}
+		for _, msg := range validation.IsValidLabelValue(t.Value) {
+			errs = append(errs, field.Invalid(path.Child("value"), t.Value, msg))
+		}
+	case corev1.TolerationOpExists:
+		if t.Value != "" {
+			errs = append(errs, field.Invalid(path.Child("value"), t.Value,
+				"value must be empty when operator is Exists"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("operator"), t.Operator,
+			[]string{string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists)}))
+	}
+	switch t.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("effect"), t.Effect,
+			[]string{string(corev1.TaintEffectNoSchedule), string(corev1.TaintEffectPreferNoSchedule), string(corev1.TaintEffectNoExecute)}))
+	}
+	if t.TolerationSeconds != nil && t.Effect != corev1.TaintEffectNoExecute {
+		errs = append(errs, field.Invalid(path.Child("effect"), t.Effect,
+			"effect must be NoExecute when tolerationSeconds is set"))
+	}
+	return errs
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// TestAgentValidate tests the Agent spec validation
+func TestAgentValidate(t *testing.T) {
+	seconds := int64(30)
+	newAgent := func(name string, spec apiv1alpha1.AgentSpec) *apiv1alpha1.Agent {
+		return &apiv1alpha1.Agent{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}, Spec: spec}
+	}
+	v := &AgentCustomValidator{Client: fake.NewClientBuilder().WithScheme(newScheme(t)).Build()}
+
+	tests := []struct {
+		name    string
+		agent   *apiv1alpha1.Agent
+		wantErr bool
+	}{
+		{"empty spec", newAgent("agent", apiv1alpha1.AgentSpec{}), false},
+		{"other pool", newAgent("edge", apiv1alpha1.AgentSpec{}), false},
+		{"name too long for a label", newAgent(strings.Repeat("a", 64), apiv1alpha1.AgentSpec{}), true},
+		{"valid selector and tolerations", newAgent("agent", apiv1alpha1.AgentSpec{
+			NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+			Tolerations: []corev1.Toleration{
+				{Operator: corev1.TolerationOpExists},
+				{Key: "node-role.kubernetes.io/control-plane", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+			},
+		}), false},
+		{"invalid selector key", newAgent("agent", apiv1alpha1.AgentSpec{
+			NodeSelector: map[string]string{"not a key": "linux"},
+		}), true},
+		{"valid node label selector", newAgent("agent", apiv1alpha1.AgentSpec{
+			NodeLabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"edge"}},
+				{Key: "node-role.kubernetes.io/control-plane", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}},
+		}), false},
+		{"node label selector In without values", newAgent("agent", apiv1alpha1.AgentSpec{
+			NodeLabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "zone", Operator: metav1.LabelSelectorOpIn},
+			}},
+		}), true},
+		{"exists with value", newAgent("agent", apiv1alpha1.AgentSpec{
+			Tolerations: []corev1.Toleration{{Key: "a", Operator: corev1.TolerationOpExists, Value: "b"}},
+		}), true},
+		{"unknown effect", newAgent("agent", apiv1alpha1.AgentSpec{
+			Tolerations: []corev1.Toleration{{Key: "a", Value: "b", Effect: "Never"}},
+		}), true},
+		{"seconds without NoExecute", newAgent("agent", apiv1alpha1.AgentSpec{
+			Tolerations: []corev1.Toleration{{Key: "a", Operator: corev1.TolerationOpExists, TolerationSeconds: &seconds}},
+		}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.agent)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestAgentValidateUniquePool tests that an Agent may not reuse the name of an Agent in another namespace
+func TestAgentValidateUniquePool(t *testing.T) {
+	existing := &apiv1alpha1.Agent{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "ip-rule-operator-system"}}
+	v := &AgentCustomValidator{Client: fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()}
+
+	tests := []struct {
+		name      string
+		agent     string
+		namespace string
+		wantErr   bool
+	}{
+		{"same name in another namespace", "edge", "default", true},
+		{"other name", "core", "default", false},
+		{"same namespace", "edge", "ip-rule-operator-system", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &apiv1alpha1.Agent{ObjectMeta: metav1.ObjectMeta{Name: tt.agent, Namespace: tt.namespace}}
+			_, err := v.ValidateCreate(context.Background(), agent)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
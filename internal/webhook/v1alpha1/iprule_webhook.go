@@ -0,0 +1,319 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var ipruleLog = logf.Log.WithName("iprule-resource")
+
+// SetupIPRuleWebhookWithManager registers the webhook for IPRule in the manager. defaultTable and
+// defaultPriority are written into IPRules that leave the respective field unset.
+func SetupIPRuleWebhookWithManager(mgr ctrl.Manager, defaultTable, defaultPriority int) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&apiv1alpha1.IPRule{}).
+		WithValidator(&IPRuleCustomValidator{Client: mgr.GetClient()}).
+		WithDefaulter(&IPRuleCustomDefaulter{DefaultTable: defaultTable, DefaultPriority: defaultPriority}).
+		Complete()
+}
+
+// ValidateRuleDefaults checks the operator-level defaults once at startup, so a bad flag fails
+// fast instead of producing IPRules the validating webhook rejects afterwards.
+func ValidateRuleDefaults(table, priority int) error {
+	var allErrs field.ErrorList
+	if err := validateTable(field.NewPath("default-table"), table, nil); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if err := validatePriority(field.NewPath("default-priority"), priority); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	return allErrs.ToAggregate()
+}
+
+// +kubebuilder:webhook:path=/mutate-api-operator-brtrm-dev-v1alpha1-iprule,mutating=true,failurePolicy=fail,sideEffects=None,groups=api.operator.brtrm.dev,resources=iprules,verbs=create;update,versions=v1alpha1,name=miprule-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// IPRuleCustomDefaulter fills in table (unless a table name or VRF is given) and priority, so the stored IPRule states exactly what
+// ends up on the nodes instead of leaving the choice to the agent or the kernel.
+type IPRuleCustomDefaulter struct {
+	DefaultTable    int
+	DefaultPriority int
+}
+
+var _ webhook.CustomDefaulter = &IPRuleCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the Kind IPRule.
+func (d *IPRuleCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	iprule, ok := obj.(*apiv1alpha1.IPRule)
+	if !ok {
+		return fmt.Errorf("expected an IPRule object but got %T", obj)
+	}
+	ipruleLog.V(1).Info("Defaulting for IPRule", "name", iprule.GetName())
+
+	if iprule.Spec.Table == 0 && iprule.Spec.TableName == "" && iprule.Spec.VRF == "" {
+		iprule.Spec.Table = d.DefaultTable
+	}
+	if iprule.Spec.Priority == 0 {
+		iprule.Spec.Priority = d.DefaultPriority
+	}
+	if iprule.Spec.Action == "" {
+		iprule.Spec.Action = apiv1alpha1.RuleActionLookup
+	}
+	if len(iprule.Spec.AddressSources) == 0 {
+		iprule.Spec.AddressSources = []apiv1alpha1.AddressSource{apiv1alpha1.AddressSourceIngressIP}
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-api-operator-brtrm-dev-v1alpha1-iprule,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.operator.brtrm.dev,resources=iprules,verbs=create;update,versions=v1alpha1,name=viprule-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// IPRuleCustomValidator validates IPRule resources on create and update. It needs a client to
+// resolve table names and to detect overlaps with the IPRules already in the cluster.
+type IPRuleCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &IPRuleCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type IPRule.
+func (v *IPRuleCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	iprule, ok := obj.(*apiv1alpha1.IPRule)
+	if !ok {
+		return nil, fmt.Errorf("expected a IPRule object but got %T", obj)
+	}
+	ipruleLog.V(1).Info("Validation for IPRule upon creation", "name", iprule.GetName())
+
+	return v.validateIPRule(ctx, iprule)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type IPRule.
+func (v *IPRuleCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	iprule, ok := newObj.(*apiv1alpha1.IPRule)
+	if !ok {
+		return nil, fmt.Errorf("expected a IPRule object for the newObj but got %T", newObj)
+	}
+	ipruleLog.V(1).Info("Validation for IPRule upon update", "name", iprule.GetName())
+
+	// Never block finalizer removal or other metadata updates of an object that is going away.
+	if !iprule.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return v.validateIPRule(ctx, iprule)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type IPRule.
+func (v *IPRuleCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *IPRuleCustomValidator) validateIPRule(ctx context.Context, iprule *apiv1alpha1.IPRule) (admission.Warnings, error) {
+	var allErrs field.ErrorList
+	var warnings admission.Warnings
+	specPath := field.NewPath("spec")
+
+	tables, err := v.tableIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix, cidrErr := validateCIDR(specPath.Child("cidr"), iprule.Spec.Cidr)
+	if cidrErr != nil {
+		allErrs = append(allErrs, cidrErr)
+	}
+	table := specTable(&iprule.Spec, tables)
+	if vrf := iprule.Spec.VRF; vrf != "" {
+		if iprule.Spec.Table != 0 || iprule.Spec.TableName != "" {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("vrf"), "must not be set together with table or tableName"))
+		}
+		if !validInterfaceName(vrf) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("vrf"), vrf, "not a valid interface name"))
+		}
+		if a := iprule.Spec.Action; a != "" && a != apiv1alpha1.RuleActionLookup {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("vrf"), "only valid for the Lookup action"))
+		}
+	} else if name := iprule.Spec.TableName; name != "" {
+		if iprule.Spec.Table != 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("table"), "must not be set together with tableName"))
+		}
+		if err := validateTableName(specPath.Child("tableName"), name); err != nil {
+			allErrs = append(allErrs, err)
+		} else if _, ok := tables[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("no RoutingTable named %q exists yet; the IPRule matches nothing until it is created", name))
+		}
+	} else if err := validateTable(specPath.Child("table"), iprule.Spec.Table, iprule.Annotations); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if err := validatePriority(specPath.Child("priority"), iprule.Spec.Priority); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if pool := iprule.Spec.AgentPool; pool != "" {
+		if errs := validateAgentPool(specPath.Child("agentPool"), pool); len(errs) > 0 {
+			allErrs = append(allErrs, errs...)
+		} else if exists, err := v.agentExists(ctx, pool); err != nil {
+			return warnings, err
+		} else if !exists {
+			warnings = append(warnings, fmt.Sprintf("no Agent named %q exists yet; the rules are not applied until it is created", pool))
+		}
+	}
+	if sel := iprule.Spec.NamespaceSelector; sel != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(sel,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("namespaceSelector"))...)
+	}
+	if sel := iprule.Spec.ServiceSelector; sel != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(sel,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("serviceSelector"))...)
+	}
+	if sel := iprule.Spec.NodeSelector; sel != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(sel,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("nodeSelector"))...)
+	}
+	allErrs = append(allErrs, validateRuleSelector(specPath, &iprule.Spec.RuleSelector, prefix.Addr())...)
+	allErrs = append(allErrs, validateRuleAction(specPath, &iprule.Spec.RuleAction, table, iprule.Spec.Priority, prefix.Addr())...)
+	if cidrErr == nil {
+		conflicts, err := v.findConflicts(ctx, iprule, prefix, tables)
+		if err != nil {
+			return warnings, err
+		}
+		allErrs = append(allErrs, conflicts...)
+	}
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+	return warnings, apierrors.NewInvalid(apiv1alpha1.GroupVersion.WithKind("IPRule").GroupKind(), iprule.Name, allErrs)
+}
+
+// tableIDs returns the table number of every RoutingTable, keyed by its name.
+func (v *IPRuleCustomValidator) tableIDs(ctx context.Context) (map[string]int, error) {
+	list := &apiv1alpha1.RoutingTableList{}
+	if err := v.Client.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("list RoutingTables: %w", err)
+	}
+	tables := make(map[string]int, len(list.Items))
+	for i := range list.Items {
+		tables[list.Items[i].Name] = list.Items[i].Spec.Table
+	}
+	return tables, nil
+}
+
+// agentExists reports whether an Agent named pool exists in any namespace.
+func (v *IPRuleCustomValidator) agentExists(ctx context.Context, pool string) (bool, error) {
+	list := &apiv1alpha1.AgentList{}
+	if err := v.Client.List(ctx, list); err != nil {
+		return false, fmt.Errorf("list Agents: %w", err)
+	}
+	for i := range list.Items {
+		if list.Items[i].Name == pool {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// specTable returns the table number of spec, resolving a table name through tables. It is 0
+// for a name no RoutingTable is registered for and for a VRF.
+func specTable(spec *apiv1alpha1.IPRuleSpec, tables map[string]int) int {
+	if spec.VRF != "" {
+		return 0
+	}
+	if spec.TableName != "" {
+		return tables[spec.TableName]
+	}
+	return spec.Table
+}
+
+// specTarget describes where the rules of spec route into, for comparison and messages.
+func specTarget(spec *apiv1alpha1.IPRuleSpec, tables map[string]int) string {
+	if spec.VRF != "" {
+		return "vrf " + spec.VRF
+	}
+	return fmt.Sprintf("table %d", specTable(spec, tables))
+}
+
+// specDifference describes how the rules of other differ from those of spec in where they route
+// to, what they do or which traffic they match; it is empty if they only differ in the CIDR.
+func specDifference(spec, other *apiv1alpha1.IPRuleSpec, tables map[string]int) string {
+	if specTarget(other, tables) != specTarget(spec, tables) {
+		return "uses a different table"
+	}
+	action, otherAction := spec.RuleAction, other.RuleAction
+	for _, a := range []*apiv1alpha1.RuleAction{&action, &otherAction} {
+		if a.Action == "" {
+			a.Action = apiv1alpha1.RuleActionLookup
+		}
+	}
+	if !equality.Semantic.DeepEqual(action, otherAction) {
+		return "uses a different action"
+	}
+	if !equality.Semantic.DeepEqual(spec.RuleSelector, other.RuleSelector) {
+		return "uses a different rule selector"
+	}
+	return ""
+}
+
+// findConflicts reports IPRules of the same agent pool whose CIDR overlaps with prefix but that
+// route into a different table, use a different action or a different rule selector. Nested CIDRs
+// are fine as long as the priorities differ (the most specific CIDR wins); an identical CIDR, or
+// an overlap at the same priority, would make the outcome depend on rule insertion order.
+func (v *IPRuleCustomValidator) findConflicts(ctx context.Context, iprule *apiv1alpha1.IPRule, prefix netip.Prefix, tables map[string]int) (field.ErrorList, error) {
+	list := &apiv1alpha1.IPRuleList{}
+	if err := v.Client.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("list IPRules: %w", err)
+	}
+	prefix = prefix.Masked()
+	var errs field.ErrorList
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Name == iprule.Name {
+			continue
+		}
+		diff := specDifference(&iprule.Spec, &other.Spec, tables)
+		if diff == "" {
+			continue
+		}
+		// Rules of different pools never meet on a node, and rules scoped to different
+		// namespaces/services (e.g. one per tenant) may share a CIDR.
+		if rulePool(&other.Spec) != rulePool(&iprule.Spec) ||
+			!equality.Semantic.DeepEqual(other.Spec.NamespaceSelector, iprule.Spec.NamespaceSelector) ||
+			!equality.Semantic.DeepEqual(other.Spec.ServiceSelector, iprule.Spec.ServiceSelector) {
+			continue
+		}
+		otherPrefix, err := netip.ParsePrefix(other.Spec.Cidr)
+		if err != nil || !prefix.Overlaps(otherPrefix.Masked()) {
+			continue
+		}
+		if prefix == otherPrefix.Masked() || other.Spec.Priority == iprule.Spec.Priority {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "cidr"), iprule.Spec.Cidr,
+				fmt.Sprintf("overlaps IPRule %q (cidr %s, %s, priority %d) which %s",
+					other.Name, other.Spec.Cidr, specTarget(&other.Spec, tables), other.Spec.Priority, diff)))
+		}
+	}
+	return errs, nil
+}
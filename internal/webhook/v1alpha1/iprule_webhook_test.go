@@ -0,0 +1,291 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+func newIPRule(name, cidr string, table, priority int) *apiv1alpha1.IPRule {
+	return &apiv1alpha1.IPRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       apiv1alpha1.IPRuleSpec{Cidr: cidr, Table: table, Priority: priority},
+	}
+}
+
+// TestIPRuleValidateCreate tests the IPRule field validation
+func TestIPRuleValidateCreate(t *testing.T) {
+	reserved := newIPRule("reserved-allowed", "10.1.0.0/24", 254, 1000)
+	reserved.Annotations = map[string]string{apiv1alpha1.AnnotationAllowReservedTable: "true"}
+	badSelector := newIPRule("bad-selector", "10.2.0.0/24", 100, 1000)
+	badSelector.Spec.ServiceSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: metav1.LabelSelectorOpIn}, // In requires values
+	}}
+	gateways := newIPRule("gateways", "10.3.0.0/24", 100, 1000)
+	gateways.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"egress-gateway": "true"}}
+	badNodeSelector := newIPRule("bad-node-selector", "10.4.0.0/24", 100, 1000)
+	badNodeSelector.Spec.NodeSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "egress-gateway", Operator: metav1.LabelSelectorOpExists, Values: []string{"true"}}, // Exists takes no values
+	}}
+	// Not a constant, so the test also compiles where int is 32 bits wide (the value then wraps
+	// to 0, which is rejected as well).
+	tooLarge := int64(math.MaxUint32) + 1
+
+	tests := []struct {
+		name    string
+		rule    *apiv1alpha1.IPRule
+		wantErr bool
+	}{
+		{"valid", newIPRule("ok", "10.0.0.0/24", 100, 1000), false},
+		{"valid ipv6", newIPRule("ok6", "2001:db8::/64", 100, 1000), false},
+		{"invalid selector", badSelector, true},
+		{"node selector", gateways, false},
+		{"invalid node selector", badNodeSelector, true},
+		{"invalid cidr", newIPRule("bad", "10.0.0.0/33", 100, 1000), true},
+		{"table zero", newIPRule("bad", "10.0.0.0/24", 0, 1000), true},
+		{"table too large", newIPRule("bad", "10.0.0.0/24", int(tooLarge), 1000), true},
+		{"reserved table", newIPRule("bad", "10.0.0.0/24", 254, 1000), true},
+		{"reserved table allowed", reserved, false},
+		{"priority zero", newIPRule("bad", "10.0.0.0/24", 100, 0), true},
+		{"priority main", newIPRule("bad", "10.0.0.0/24", 100, 32766), true},
+		{"priority default", newIPRule("bad", "10.0.0.0/24", 100, 32767), true},
+		{"negative priority", newIPRule("bad", "10.0.0.0/24", 100, -1), true},
+	}
+
+	v := &IPRuleCustomValidator{Client: fake.NewClientBuilder().WithScheme(newScheme(t)).Build()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestIPRuleValidateOverlap tests the conflict detection against existing IPRules
+func TestIPRuleValidateOverlap(t *testing.T) {
+	existing := newIPRule("existing", "10.0.0.0/24", 100, 1000)
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(existing).Build()
+	v := &IPRuleCustomValidator{Client: c}
+	tenantRule := newIPRule("tenant", "10.0.0.0/24", 200, 1000)
+	tenantRule.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "b"}}
+	withAction := func(name string, action apiv1alpha1.RuleActionType, priority int) *apiv1alpha1.IPRule {
+		rule := newIPRule(name, "10.0.0.0/28", 100, priority)
+		rule.Spec.Action = action
+		return rule
+	}
+	mark := int64(0x1)
+	marked := newIPRule("marked", "10.0.0.0/28", 100, 1000)
+	marked.Spec.FwMark = &mark
+
+	tests := []struct {
+		name    string
+		rule    *apiv1alpha1.IPRule
+		wantErr bool
+	}{
+		{"same cidr same table", newIPRule("dup", "10.0.0.0/24", 100, 2000), false},
+		{"same cidr other table", newIPRule("dup", "10.0.0.0/24", 200, 2000), true},
+		{"nested other table other priority", newIPRule("nested", "10.0.0.0/28", 200, 900), false},
+		{"nested other table same priority", newIPRule("nested", "10.0.0.0/28", 200, 1000), true},
+		{"disjoint", newIPRule("other", "10.0.1.0/24", 200, 1000), false},
+		{"update of itself", newIPRule("existing", "10.0.0.0/24", 300, 1000), false},
+		{"same cidr other tenant", tenantRule, false},
+		{"explicit lookup same priority", withAction("lookup", apiv1alpha1.RuleActionLookup, 1000), false},
+		{"blackhole same priority", withAction("drop", apiv1alpha1.RuleActionBlackhole, 1000), true},
+		{"blackhole other priority", withAction("drop", apiv1alpha1.RuleActionBlackhole, 900), false},
+		{"rule selector same priority", marked, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestIPRuleValidateTableName tests IPRules referring to a RoutingTable by name
+func TestIPRuleValidateTableName(t *testing.T) {
+	uplink := &apiv1alpha1.RoutingTable{
+		ObjectMeta: metav1.ObjectMeta{Name: "uplink-a"},
+		Spec:       apiv1alpha1.RoutingTableSpec{Table: 200},
+	}
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(newIPRule("existing", "10.0.0.0/24", 100, 1000), uplink).Build()
+	v := &IPRuleCustomValidator{Client: c}
+	named := func(name, cidr, tableName string, priority int) *apiv1alpha1.IPRule {
+		rule := newIPRule(name, cidr, 0, priority)
+		rule.Spec.TableName = tableName
+		return rule
+	}
+	both := named("both", "10.1.0.0/24", "uplink-a", 1000)
+	both.Spec.Table = 200
+
+	tests := []struct {
+		name        string
+		rule        *apiv1alpha1.IPRule
+		wantErr     bool
+		wantWarning bool
+	}{
+		{"registered name", named("ok", "10.1.0.0/24", "uplink-a", 1000), false, false},
+		{"unknown name", named("later", "10.1.0.0/24", "uplink-b", 1000), false, true},
+		{"table and name", both, true, false},
+		{"reserved name", named("bad", "10.1.0.0/24", "main", 1000), true, false},
+		{"numeric name", named("bad", "10.1.0.0/24", "200", 1000), true, false},
+		{"overlap resolved table", named("dup", "10.0.0.0/24", "uplink-a", 2000), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := v.ValidateCreate(context.Background(), tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(warnings) > 0) != tt.wantWarning {
+				t.Errorf("ValidateCreate() warnings = %v, wantWarning %v", warnings, tt.wantWarning)
+			}
+		})
+	}
+
+	d := &IPRuleCustomDefaulter{DefaultTable: 100, DefaultPriority: 1000}
+	rule := named("defaults", "10.1.0.0/24", "uplink-a", 0)
+	if err := d.Default(context.Background(), rule); err != nil {
+		t.Fatalf("Default() error = %v", err)
+	}
+	if rule.Spec.Table != 0 {
+		t.Errorf("Expected no default table next to a table name, got %d", rule.Spec.Table)
+	}
+}
+
+// TestIPRuleValidateVRF tests IPRules routing into a VRF
+func TestIPRuleValidateVRF(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(newIPRule("existing", "10.0.0.0/24", 100, 1000)).Build()
+	v := &IPRuleCustomValidator{Client: c}
+	vrf := func(name, cidr string, table int, device string) *apiv1alpha1.IPRule {
+		rule := newIPRule(name, cidr, table, 1000)
+		rule.Spec.VRF = device
+		return rule
+	}
+	blackhole := vrf("bad", "10.1.0.0/24", 0, "vrf-blue")
+	blackhole.Spec.Action = apiv1alpha1.RuleActionBlackhole
+
+	tests := []struct {
+		name    string
+		rule    *apiv1alpha1.IPRule
+		wantErr bool
+	}{
+		{"valid", vrf("ok", "10.1.0.0/24", 0, "vrf-blue"), false},
+		{"vrf and table", vrf("bad", "10.1.0.0/24", 100, "vrf-blue"), true},
+		{"invalid name", vrf("bad", "10.1.0.0/24", 0, "vrf blue"), true},
+		{"not lookup", blackhole, true},
+		{"overlap with table", vrf("dup", "10.0.0.0/24", 0, "vrf-blue"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestIPRuleValidateAgentPool tests IPRules targeting an agent pool
+func TestIPRuleValidateAgentPool(t *testing.T) {
+	edge := &apiv1alpha1.Agent{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "ip-rule-operator-system"}}
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(newIPRule("existing", "10.0.0.0/24", 100, 1000), edge).Build()
+	v := &IPRuleCustomValidator{Client: c}
+	pooled := func(name, pool string, table int) *apiv1alpha1.IPRule {
+		rule := newIPRule(name, "10.0.0.0/24", table, 1000)
+		rule.Spec.AgentPool = pool
+		return rule
+	}
+
+	tests := []struct {
+		name        string
+		rule        *apiv1alpha1.IPRule
+		wantErr     bool
+		wantWarning bool
+	}{
+		{"existing pool", pooled("edge", "edge", 200), false, false},
+		{"unknown pool", pooled("later", "core", 200), false, true},
+		{"invalid pool", pooled("bad", "Edge_Pool", 200), true, false},
+		{"overlap in the default pool", pooled("dup", apiv1alpha1.DefaultAgentPool, 200), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := v.ValidateCreate(context.Background(), tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (len(warnings) > 0) != tt.wantWarning {
+				t.Errorf("ValidateCreate() warnings = %v, wantWarning %v", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := apiv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("add scheme: %v", err)
+	}
+	return scheme
+}
+
+// TestIPRuleDefault tests that unset table and priority are filled with the operator defaults
+func TestIPRuleDefault(t *testing.T) {
+	d := &IPRuleCustomDefaulter{DefaultTable: 100, DefaultPriority: 1000}
+
+	rule := newIPRule("defaults", "10.0.0.0/24", 0, 0)
+	if err := d.Default(context.Background(), rule); err != nil {
+		t.Fatalf("Default() error = %v", err)
+	}
+	if rule.Spec.Table != 100 || rule.Spec.Priority != 1000 {
+		t.Errorf("Expected defaults 100/1000, got %d/%d", rule.Spec.Table, rule.Spec.Priority)
+	}
+	if len(rule.Spec.AddressSources) != 1 || rule.Spec.AddressSources[0] != apiv1alpha1.AddressSourceIngressIP {
+		t.Errorf("Expected address sources to default to [IngressIP], got %v", rule.Spec.AddressSources)
+	}
+	if rule.Spec.Action != apiv1alpha1.RuleActionLookup {
+		t.Errorf("Expected action to default to Lookup, got %q", rule.Spec.Action)
+	}
+
+	explicit := newIPRule("explicit", "10.0.0.0/24", 200, 2000)
+	if err := d.Default(context.Background(), explicit); err != nil {
+		t.Fatalf("Default() error = %v", err)
+	}
+	if explicit.Spec.Table != 200 || explicit.Spec.Priority != 2000 {
+		t.Errorf("Expected explicit values to be kept, got %d/%d", explicit.Spec.Table, explicit.Spec.Priority)
+	}
+
+	if err := ValidateRuleDefaults(100, 1000); err != nil {
+		t.Errorf("ValidateRuleDefaults() error = %v", err)
+	}
+	if err := ValidateRuleDefaults(254, 32766); err == nil {
+		t.Error("Expected ValidateRuleDefaults() to reject a reserved table and kernel priority")
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var ipruleconfigLog = logf.Log.WithName("ipruleconfig-resource")
+
+// SetupIPRuleConfigWebhookWithManager registers the webhook for IPRuleConfig in the manager.
+func SetupIPRuleConfigWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&apiv1alpha1.IPRuleConfig{}).
+		WithValidator(&IPRuleConfigCustomValidator{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-api-operator-brtrm-dev-v1alpha1-ipruleconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.operator.brtrm.dev,resources=ipruleconfigs,verbs=create;update,versions=v1alpha1,name=vipruleconfig-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// IPRuleConfigCustomValidator validates IPRuleConfig resources, mainly to catch hand-edited
+// objects the agent would otherwise silently skip.
+type IPRuleConfigCustomValidator struct{}
+
+var _ webhook.CustomValidator = &IPRuleConfigCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type IPRuleConfig.
+func (v *IPRuleConfigCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	cfg, ok := obj.(*apiv1alpha1.IPRuleConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected a IPRuleConfig object but got %T", obj)
+	}
+	ipruleconfigLog.V(1).Info("Validation for IPRuleConfig upon creation", "name", cfg.GetName())
+
+	return nil, validateIPRuleConfig(cfg)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type IPRuleConfig.
+func (v *IPRuleConfigCustomValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	cfg, ok := newObj.(*apiv1alpha1.IPRuleConfig)
+	if !ok {
+		return nil, fmt.Errorf("expected a IPRuleConfig object for the newObj but got %T", newObj)
+	}
+	ipruleconfigLog.V(1).Info("Validation for IPRuleConfig upon update", "name", cfg.GetName())
+
+	// Configs on their way out (absent / deleting) are not validated, so cleanup of objects
+	// created before the webhook existed is never blocked.
+	if cfg.Spec.State == apiv1alpha1.StateAbsent || !cfg.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, validateIPRuleConfig(cfg)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type IPRuleConfig.
+func (v *IPRuleConfigCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateIPRuleConfig(cfg *apiv1alpha1.IPRuleConfig) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	serviceIP, err := netip.ParseAddr(cfg.Spec.ServiceIP)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("serviceIP"), cfg.Spec.ServiceIP, err.Error()))
+	}
+	switch cfg.Spec.State {
+	case apiv1alpha1.StatePresent, apiv1alpha1.StateAbsent:
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("state"), cfg.Spec.State,
+			[]string{apiv1alpha1.StatePresent, apiv1alpha1.StateAbsent}))
+	}
+	if cfg.Spec.VRF != "" {
+		if cfg.Spec.Table != 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("table"), "must be 0 when vrf is set"))
+		}
+		if !validInterfaceName(cfg.Spec.VRF) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("vrf"), cfg.Spec.VRF, "not a valid interface name"))
+		}
+		if a := cfg.Spec.Action; a != "" && a != apiv1alpha1.RuleActionLookup {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("vrf"), "only valid for the Lookup action"))
+		}
+	} else if err := validateTable(specPath.Child("table"), cfg.Spec.Table, cfg.Annotations); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if err := validatePriority(specPath.Child("priority"), cfg.Spec.Priority); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	if pool := cfg.Spec.AgentPool; pool != "" {
+		allErrs = append(allErrs, validateAgentPool(specPath.Child("agentPool"), pool)...)
+	}
+	if sel := cfg.Spec.NodeSelector; sel != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(sel,
+			metav1validation.LabelSelectorValidationOptions{}, specPath.Child("nodeSelector"))...)
+	}
+	allErrs = append(allErrs, validateRuleSelector(specPath, &cfg.Spec.RuleSelector, serviceIP.Unmap())...)
+	allErrs = append(allErrs, validateRuleAction(specPath, &cfg.Spec.RuleAction, cfg.Spec.Table, cfg.Spec.Priority, serviceIP.Unmap())...)
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(apiv1alpha1.GroupVersion.WithKind("IPRuleConfig").GroupKind(), cfg.Name, allErrs)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// TestIPRuleConfigValidate tests the IPRuleConfig field validation
+func TestIPRuleConfigValidate(t *testing.T) {
+	newCfg := func(ip string, table, priority int, state string) *apiv1alpha1.IPRuleConfig {
+		return &apiv1alpha1.IPRuleConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "iprc-test"},
+			Spec:       apiv1alpha1.IPRuleConfigSpec{ServiceIP: ip, Table: table, Priority: priority, State: state},
+		}
+	}
+	withVRF := func(cfg *apiv1alpha1.IPRuleConfig, vrf string) *apiv1alpha1.IPRuleConfig {
+		cfg.Spec.VRF = vrf
+		return cfg
+	}
+	withPool := func(cfg *apiv1alpha1.IPRuleConfig, pool string) *apiv1alpha1.IPRuleConfig {
+		cfg.Spec.AgentPool = pool
+		return cfg
+	}
+	withNodes := func(cfg *apiv1alpha1.IPRuleConfig, op metav1.LabelSelectorOperator) *apiv1alpha1.IPRuleConfig {
+		cfg.Spec.NodeSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "egress-gateway", Operator: op},
+		}}
+		return cfg
+	}
+	v := &IPRuleConfigCustomValidator{}
+
+	tests := []struct {
+		name    string
+		cfg     *apiv1alpha1.IPRuleConfig
+		wantErr bool
+	}{
+		{"valid", newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), false},
+		{"valid ipv6", newCfg("fd00::10", 100, 1000, apiv1alpha1.StatePresent), false},
+		{"invalid ip", newCfg("10.96.0", 100, 1000, apiv1alpha1.StatePresent), true},
+		{"cidr instead of ip", newCfg("10.96.0.0/24", 100, 1000, apiv1alpha1.StatePresent), true},
+		{"unknown state", newCfg("10.96.0.10", 100, 1000, "gone"), true},
+		{"local table", newCfg("10.96.0.10", 255, 1000, apiv1alpha1.StatePresent), true},
+		{"kernel priority", newCfg("10.96.0.10", 100, 32766, apiv1alpha1.StatePresent), true},
+		{"vrf", withVRF(newCfg("10.96.0.10", 0, 1000, apiv1alpha1.StatePresent), "vrf-blue"), false},
+		{"vrf and table", withVRF(newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), "vrf-blue"), true},
+		{"invalid vrf name", withVRF(newCfg("10.96.0.10", 0, 1000, apiv1alpha1.StatePresent), "vrf/blue"), true},
+		{"agent pool", withPool(newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), "edge"), false},
+		{"invalid agent pool", withPool(newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), "edge/b"), true},
+		{"node selector", withNodes(newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), metav1.LabelSelectorOpExists), false},
+		{"invalid node selector", withNodes(newCfg("10.96.0.10", 100, 1000, apiv1alpha1.StatePresent), metav1.LabelSelectorOpIn), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Marking a legacy config absent must not be blocked by the validation
+	legacy := newCfg("10.96.0.10", 100, 0, apiv1alpha1.StateAbsent)
+	if _, err := v.ValidateUpdate(context.Background(), legacy, legacy); err != nil {
+		t.Errorf("ValidateUpdate() of absent config returned error: %v", err)
+	}
+}
+
+// TestIPRuleConfigValidateSelector tests the cross-field checks of the rule selector
+func TestIPRuleConfigValidateSelector(t *testing.T) {
+	newCfg := func(ip string, sel apiv1alpha1.RuleSelector) *apiv1alpha1.IPRuleConfig {
+		return &apiv1alpha1.IPRuleConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "iprc-test"},
+			Spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: ip, Table: 100, Priority: 1000,
+				State: apiv1alpha1.StatePresent, RuleSelector: sel},
+		}
+	}
+	mark := int64(0x10)
+	v := &IPRuleConfigCustomValidator{}
+
+	tests := []struct {
+		name    string
+		cfg     *apiv1alpha1.IPRuleConfig
+		wantErr bool
+	}{
+		{"full selector", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{
+			FwMark: &mark, FwMask: &mark, IIF: "eth1", Dst: "10.0.0.0/8", TOS: 0x10,
+			IPProto: apiv1alpha1.IPProtocolTCP, DstPort: &apiv1alpha1.PortRange{Start: 443},
+			UIDRange: &apiv1alpha1.UIDRange{Start: 1000, End: 2000}, Invert: true,
+		}), false},
+		{"mask without mark", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{FwMask: &mark}), true},
+		{"invalid iif", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{IIF: "eth/1"}), true},
+		{"dst of other family", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{Dst: "fd00::/8"}), true},
+		{"icmp on ipv6", newCfg("fd00::10", apiv1alpha1.RuleSelector{IPProto: apiv1alpha1.IPProtocolICMP}), true},
+		{"port without proto", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{SrcPort: &apiv1alpha1.PortRange{Start: 53}}), true},
+		{"reversed port range", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{
+			IPProto: apiv1alpha1.IPProtocolUDP, DstPort: &apiv1alpha1.PortRange{Start: 2000, End: 1000},
+		}), true},
+		{"reversed uid range", newCfg("10.96.0.10", apiv1alpha1.RuleSelector{UIDRange: &apiv1alpha1.UIDRange{Start: 5, End: 1}}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestIPRuleConfigValidateAction tests that action parameters fit the action
+func TestIPRuleConfigValidateAction(t *testing.T) {
+	newCfg := func(ip string, table int, action apiv1alpha1.RuleAction) *apiv1alpha1.IPRuleConfig {
+		return &apiv1alpha1.IPRuleConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "iprc-test"},
+			Spec: apiv1alpha1.IPRuleConfigSpec{ServiceIP: ip, Table: table, Priority: 1000,
+				State: apiv1alpha1.StatePresent, RuleAction: action},
+		}
+	}
+	zero, v6 := int32(0), int32(64)
+	v := &IPRuleConfigCustomValidator{}
+
+	tests := []struct {
+		name    string
+		cfg     *apiv1alpha1.IPRuleConfig
+		wantErr bool
+	}{
+		{"blackhole", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionBlackhole}), false},
+		{"goto forward", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionGoto, GotoPriority: 2000}), false},
+		{"goto backward", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionGoto, GotoPriority: 500}), true},
+		{"goto priority without goto", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{GotoPriority: 2000}), true},
+		{"suppress default route", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{SuppressPrefixLength: &zero}), false},
+		{"suppress on prohibit", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{Action: apiv1alpha1.RuleActionProhibit, SuppressPrefixLength: &zero}), true},
+		{"suppress with large table", newCfg("10.96.0.10", 1000, apiv1alpha1.RuleAction{SuppressPrefixLength: &zero}), true},
+		{"suppress longer than ipv4", newCfg("10.96.0.10", 100, apiv1alpha1.RuleAction{SuppressPrefixLength: &v6}), true},
+		{"suppress ipv6", newCfg("fd00::10", 100, apiv1alpha1.RuleAction{SuppressPrefixLength: &v6}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var routingtableLog = logf.Log.WithName("routingtable-resource")
+
+// SetupRoutingTableWebhookWithManager registers the webhook for RoutingTable in the manager.
+func SetupRoutingTableWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&apiv1alpha1.RoutingTable{}).
+		WithValidator(&RoutingTableCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-api-operator-brtrm-dev-v1alpha1-routingtable,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.operator.brtrm.dev,resources=routingtables,verbs=create;update,versions=v1alpha1,name=vroutingtable-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// RoutingTableCustomValidator validates RoutingTable resources on create and update. It needs a
+// client to reject a second RoutingTable for the same table id.
+type RoutingTableCustomValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &RoutingTableCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type RoutingTable.
+func (v *RoutingTableCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	rt, ok := obj.(*apiv1alpha1.RoutingTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a RoutingTable object but got %T", obj)
+	}
+	routingtableLog.V(1).Info("Validation for RoutingTable upon creation", "name", rt.GetName())
+
+	return nil, v.validateRoutingTable(ctx, rt)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type RoutingTable.
+func (v *RoutingTableCustomValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	rt, ok := newObj.(*apiv1alpha1.RoutingTable)
+	if !ok {
+		return nil, fmt.Errorf("expected a RoutingTable object for the newObj but got %T", newObj)
+	}
+	routingtableLog.V(1).Info("Validation for RoutingTable upon update", "name", rt.GetName())
+
+	if !rt.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return nil, v.validateRoutingTable(ctx, rt)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type RoutingTable.
+func (v *RoutingTableCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *RoutingTableCustomValidator) validateRoutingTable(ctx context.Context, rt *apiv1alpha1.RoutingTable) error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if err := validateTableName(field.NewPath("metadata", "name"), rt.Name); err != nil {
+		allErrs = append(allErrs, err)
+	}
+	tableErr := validateTable(specPath.Child("table"), rt.Spec.Table, rt.Annotations)
+	if tableErr != nil {
+		allErrs = append(allErrs, tableErr)
+	}
+	allErrs = append(allErrs, validateRoutes(specPath.Child("routes"), rt.Spec.Routes)...)
+	for i, o := range rt.Spec.NodeOverrides {
+		path := specPath.Child("nodeOverrides").Index(i)
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(&o.NodeSelector,
+			metav1validation.LabelSelectorValidationOptions{}, path.Child("nodeSelector"))...)
+		allErrs = append(allErrs, validateRoutes(path.Child("routes"), o.Routes)...)
+	}
+	if tableErr == nil {
+		list := &apiv1alpha1.RoutingTableList{}
+		if err := v.Client.List(ctx, list); err != nil {
+			return fmt.Errorf("list RoutingTables: %w", err)
+		}
+		// Two objects for one table would make the agents delete each other's routes.
+		for i := range list.Items {
+			if other := &list.Items[i]; other.Name != rt.Name && other.Spec.Table == rt.Spec.Table {
+				allErrs = append(allErrs, field.Duplicate(specPath.Child("table"), rt.Spec.Table))
+			}
+		}
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(apiv1alpha1.GroupVersion.WithKind("RoutingTable").GroupKind(), rt.Name, allErrs)
+}
+
+// validateRoutes checks every route of a list and rejects a destination listed twice with the
+// same metric, which the kernel would treat as one route.
+func validateRoutes(path *field.Path, routes []apiv1alpha1.Route) field.ErrorList {
+	var errs field.ErrorList
+	seen := map[string]bool{}
+	for i, r := range routes {
+		routePath := path.Index(i)
+		dst, err := netip.ParsePrefix(r.Destination)
+		if err != nil {
+			errs = append(errs, field.Invalid(routePath.Child("destination"), r.Destination, err.Error()))
+		} else {
+			key := fmt.Sprintf("%s|%d", dst.Masked(), r.Metric)
+			if seen[key] {
+				errs = append(errs, field.Duplicate(routePath.Child("destination"), r.Destination))
+			}
+			seen[key] = true
+		}
+		if r.Gateway != "" {
+			gw, err := netip.ParseAddr(r.Gateway)
+			if err != nil {
+				errs = append(errs, field.Invalid(routePath.Child("gateway"), r.Gateway, err.Error()))
+			} else if dst.IsValid() && gw.Unmap().Is4() != dst.Addr().Unmap().Is4() {
+				errs = append(errs, field.Invalid(routePath.Child("gateway"), r.Gateway, "must be of the same IP family as the destination"))
+			}
+		} else if r.Device == "" {
+			errs = append(errs, field.Required(routePath.Child("device"), "must be set for routes without gateway"))
+		}
+		if r.Device != "" && !validInterfaceName(r.Device) {
+			errs = append(errs, field.Invalid(routePath.Child("device"), r.Device, "not a valid interface name"))
+		}
+		if r.OnLink && (r.Gateway == "" || r.Device == "") {
+			errs = append(errs, field.Required(routePath.Child("onLink"), "requires gateway and device"))
+		}
+	}
+	return errs
+}
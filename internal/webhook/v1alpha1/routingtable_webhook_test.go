@@ -0,0 +1,75 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+func newRoutingTable(name string, table int, routes ...apiv1alpha1.Route) *apiv1alpha1.RoutingTable {
+	return &apiv1alpha1.RoutingTable{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       apiv1alpha1.RoutingTableSpec{Table: table, Routes: routes},
+	}
+}
+
+// TestRoutingTableValidate tests the RoutingTable field validation and the duplicate table check
+func TestRoutingTableValidate(t *testing.T) {
+	gateway := apiv1alpha1.Route{Destination: "0.0.0.0/0", Gateway: "192.168.100.1"}
+	badOverride := newRoutingTable("bad-override", 300, gateway)
+	badOverride.Spec.NodeOverrides = []apiv1alpha1.RouteOverride{{
+		NodeSelector: metav1.LabelSelector{MatchLabels: map[string]string{"zone": "b"}},
+		Routes:       []apiv1alpha1.Route{{Destination: "0.0.0.0/0"}},
+	}}
+
+	tests := []struct {
+		name    string
+		rt      *apiv1alpha1.RoutingTable
+		wantErr bool
+	}{
+		{"valid", newRoutingTable("ok", 200, gateway, apiv1alpha1.Route{Destination: "192.168.100.0/24", Device: "eth1"}), false},
+		{"valid ipv6", newRoutingTable("ok6", 200, apiv1alpha1.Route{Destination: "::/0", Gateway: "fd00::1", Device: "eth1", OnLink: true}), false},
+		{"duplicate table", newRoutingTable("dup", 100, gateway), true},
+		{"main table", newRoutingTable("main-table", 254, gateway), true},
+		{"reserved name", newRoutingTable("main", 200, gateway), true},
+		{"numeric name", newRoutingTable("200", 200, gateway), true},
+		{"hex name", newRoutingTable("0xc8", 200, gateway), true},
+		{"invalid destination", newRoutingTable("bad", 200, apiv1alpha1.Route{Destination: "default", Gateway: "192.168.100.1"}), true},
+		{"gateway of other family", newRoutingTable("bad", 200, apiv1alpha1.Route{Destination: "0.0.0.0/0", Gateway: "fd00::1"}), true},
+		{"no gateway and no device", newRoutingTable("bad", 200, apiv1alpha1.Route{Destination: "10.0.0.0/8"}), true},
+		{"onlink without device", newRoutingTable("bad", 200, apiv1alpha1.Route{Destination: "0.0.0.0/0", Gateway: "192.168.100.1", OnLink: true}), true},
+		{"duplicate route", newRoutingTable("bad", 200, gateway, gateway), true},
+		{"invalid override route", badOverride, true},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(newRoutingTable("existing", 100, gateway)).Build()
+	v := &RoutingTableCustomValidator{Client: c}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.ValidateCreate(context.Background(), tt.rt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
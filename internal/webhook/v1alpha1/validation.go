@@ -0,0 +1,202 @@
+/*
+Copyright 2025 Marius Bertram.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	apiv1alpha1 "github.com/mariusbertram/ip-rule-operator/api/v1alpha1"
+)
+
+// Reserved routing tables (see /etc/iproute2/rt_tables).
+const (
+	tableDefault = 253
+	tableMain    = 254
+	tableLocal   = 255
+)
+
+// reservedTableNames are resolved by iproute2 itself, whatever rt_tables says.
+var reservedTableNames = map[string]bool{
+	"unspec":  true,
+	"default": true,
+	"main":    true,
+	"local":   true,
+}
+
+// Priorities of the rules the kernel installs on its own (local, main, default).
+var kernelPriorities = map[int]string{
+	0:     "local",
+	32766: "main",
+	32767: "default",
+}
+
+// validateTable checks that table is a usable routing table id. The reserved tables are only
+// accepted when the object carries the allow-reserved-table annotation.
+func validateTable(path *field.Path, table int, annotations map[string]string) *field.Error {
+	if table < 1 || int64(table) > math.MaxUint32 {
+		return field.Invalid(path, table, "must be between 1 and 4294967295")
+	}
+	switch table {
+	case tableDefault, tableMain, tableLocal:
+		if annotations[apiv1alpha1.AnnotationAllowReservedTable] != "true" {
+			return field.Invalid(path, table,
+				"tables 253 (default), 254 (main) and 255 (local) are reserved; set annotation "+
+					apiv1alpha1.AnnotationAllowReservedTable+"=true to use them")
+		}
+	}
+	return nil
+}
+
+// validateTableName checks that name can be registered as a table name in rt_tables. iproute2
+// takes anything strtoul accepts as a table id, so numeric names would never resolve.
+func validateTableName(path *field.Path, name string) *field.Error {
+	if reservedTableNames[name] {
+		return field.Invalid(path, name, "is the name of a reserved table")
+	}
+	if _, err := strconv.ParseUint(name, 0, 32); err == nil {
+		return field.Invalid(path, name, "must not be a number")
+	}
+	return nil
+}
+
+// validateAgentPool checks that pool can be the name of an Agent, which is also used as a pod
+// label value.
+func validateAgentPool(path *field.Path, pool string) field.ErrorList {
+	var errs field.ErrorList
+	for _, msg := range append(validation.IsDNS1123Subdomain(pool), validation.IsValidLabelValue(pool)...) {
+		errs = append(errs, field.Invalid(path, pool, msg))
+	}
+	return errs
+}
+
+// rulePool returns the agent pool of spec, resolving the default.
+func rulePool(spec *apiv1alpha1.IPRuleSpec) string {
+	if spec.AgentPool == "" {
+		return apiv1alpha1.DefaultAgentPool
+	}
+	return spec.AgentPool
+}
+
+// validatePriority checks that priority is in range and does not shadow one of the kernel's
+// default rules.
+func validatePriority(path *field.Path, priority int) *field.Error {
+	if name, ok := kernelPriorities[priority]; ok {
+		return field.Invalid(path, priority, "collides with the kernel's "+name+" table rule")
+	}
+	if priority < 1 || int64(priority) > math.MaxUint32 {
+		return field.Invalid(path, priority, "must be between 1 and 4294967295")
+	}
+	return nil
+}
+
+// validateCIDR parses cidr and reports a field error if it is not a valid prefix.
+func validateCIDR(path *field.Path, cidr string) (netip.Prefix, *field.Error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, field.Invalid(path, cidr, err.Error())
+	}
+	return prefix, nil
+}
+
+// validateRuleSelector checks the cross-field constraints of a RuleSelector the CRD schema cannot
+// express. src is the source address of the rule; the family checks are skipped while it is
+// invalid, as that is reported on its own field.
+func validateRuleSelector(path *field.Path, sel *apiv1alpha1.RuleSelector, src netip.Addr) field.ErrorList {
+	var errs field.ErrorList
+	if sel.FwMask != nil && sel.FwMark == nil {
+		errs = append(errs, field.Required(path.Child("fwMark"), "must be set when fwMask is set"))
+	}
+	if sel.IIF != "" && !validInterfaceName(sel.IIF) {
+		errs = append(errs, field.Invalid(path.Child("iif"), sel.IIF, "not a valid interface name"))
+	}
+	if sel.OIF != "" && !validInterfaceName(sel.OIF) {
+		errs = append(errs, field.Invalid(path.Child("oif"), sel.OIF, "not a valid interface name"))
+	}
+	if sel.Dst != "" {
+		dst, err := netip.ParsePrefix(sel.Dst)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("dst"), sel.Dst, err.Error()))
+		} else if src.IsValid() && dst.Addr().Is4() != src.Is4() {
+			errs = append(errs, field.Invalid(path.Child("dst"), sel.Dst, "must be of the same IP family as the source"))
+		}
+	}
+	if src.IsValid() {
+		if (sel.IPProto == apiv1alpha1.IPProtocolICMP && !src.Is4()) || (sel.IPProto == apiv1alpha1.IPProtocolICMPv6 && src.Is4()) {
+			errs = append(errs, field.Invalid(path.Child("ipProto"), sel.IPProto, "does not match the IP family of the source"))
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		ports *apiv1alpha1.PortRange
+	}{{"srcPort", sel.SrcPort}, {"dstPort", sel.DstPort}} {
+		name, ports := p.name, p.ports
+		if ports == nil {
+			continue
+		}
+		switch sel.IPProto {
+		case apiv1alpha1.IPProtocolTCP, apiv1alpha1.IPProtocolUDP, apiv1alpha1.IPProtocolSCTP:
+		default:
+			errs = append(errs, field.Required(path.Child("ipProto"), "must be tcp, udp or sctp when "+name+" is set"))
+		}
+		if ports.End != 0 && ports.End < ports.Start {
+			errs = append(errs, field.Invalid(path.Child(name, "end"), ports.End, "must not be lower than start"))
+		}
+	}
+	if sel.UIDRange != nil && sel.UIDRange.End < sel.UIDRange.Start {
+		errs = append(errs, field.Invalid(path.Child("uidRange", "end"), sel.UIDRange.End, "must not be lower than start"))
+	}
+	return errs
+}
+
+// validateRuleAction checks that the parameters of a RuleAction fit its action. table and priority
+// are the rule's own; src its source address (family checks are skipped while it is invalid).
+func validateRuleAction(path *field.Path, action *apiv1alpha1.RuleAction, table, priority int, src netip.Addr) field.ErrorList {
+	var errs field.ErrorList
+	lookup := action.Action == "" || action.Action == apiv1alpha1.RuleActionLookup
+	if action.Action == apiv1alpha1.RuleActionGoto {
+		if action.GotoPriority <= int64(priority) {
+			errs = append(errs, field.Invalid(path.Child("gotoPriority"), action.GotoPriority,
+				"must be higher than the rule priority, the kernel does not support backward goto"))
+		}
+	} else if action.GotoPriority != 0 {
+		errs = append(errs, field.Forbidden(path.Child("gotoPriority"), "only valid for the Goto action"))
+	}
+	if l := action.SuppressPrefixLength; l != nil {
+		if !lookup {
+			errs = append(errs, field.Forbidden(path.Child("suppressPrefixLength"), "only valid for the Lookup action"))
+		} else if table > 255 {
+			errs = append(errs, field.Forbidden(path.Child("suppressPrefixLength"), "only supported for tables below 256"))
+		} else if src.IsValid() && int(*l) > src.BitLen() {
+			errs = append(errs, field.Invalid(path.Child("suppressPrefixLength"), *l, "exceeds the address length of the source"))
+		}
+	}
+	return errs
+}
+
+// validInterfaceName mirrors the kernel's dev_valid_name.
+func validInterfaceName(name string) bool {
+	if name == "" || len(name) > 15 || name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, "/: \t\n")
+}